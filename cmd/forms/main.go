@@ -1,6 +1,8 @@
 package main
 
 import (
+	"fmt"
+
 	"github.com/cagnosolutions/go-web-ddd/pkg/webapp/forms"
 )
 
@@ -9,8 +11,9 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	// use contact form, it's just a string of HTML
-	_ = contactForm
+	// use contact form, it renders to a string of HTML
+	contactForm.Action = "/contact-us"
+	fmt.Println(contactForm)
 }
 
 // ContactUs is just a normal struct
@@ -20,9 +23,9 @@ func main() {
 // id's are also automatically inferred.
 type ContactUs struct {
 	Name    string `html:"placeholder='your full name'"`
-	Subject string `html:"help='100 characters, max'"`
+	Subject string `html:"help='100 characters, max', maxlen=100"`
 	Message string `html:""`
-	Sender  string `html:"help='A valid email address, please'"`
+	Sender  string `html:"type=email, help='A valid email address, please'"`
 }
 
 func ContactUsForm() *forms.Form {
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"fmt"
 	"github.com/cagnosolutions/go-web-ddd/cmd/routetesting/match"
 	"github.com/cagnosolutions/go-web-ddd/cmd/routetesting/matchv2"
 	"github.com/cagnosolutions/go-web-ddd/cmd/routetesting/reswitch"
@@ -9,6 +10,7 @@ import (
 	"github.com/cagnosolutions/go-web-ddd/cmd/routetesting/shiftpath"
 	"github.com/cagnosolutions/go-web-ddd/cmd/routetesting/split"
 	"github.com/cagnosolutions/go-web-ddd/cmd/routetesting/webappr"
+	"github.com/cagnosolutions/go-web-ddd/pkg/webapp"
 	"net/http"
 	"net/http/httptest"
 	"sort"
@@ -25,6 +27,7 @@ var routers = map[string]http.Handler{
 	"retable":   http.HandlerFunc(retable.Serve),
 	"shiftpath": http.HandlerFunc(shiftpath.Serve),
 	"split":     http.HandlerFunc(split.Serve),
+	"webapp":    webappm,
 }
 
 var routerNames = func() []string {
@@ -159,10 +162,86 @@ func BenchmarkRouters(b *testing.B) {
 	}
 }
 
-type noopResponseWriter struct{}
+func BenchmarkRoutersByPath(b *testing.B) {
+	paths := []struct {
+		name   string
+		method string
+		path   string
+	}{
+		{"static", "GET", "/contact"},
+		{"param", "POST", "/api/widgets/foo"},
+		{"deep", "POST", "/api/widgets/foo/parts/1/update"},
+		{"root-param", "GET", "/foo/admin"},
+		{"not-found", "GET", "/foo/no"},
+	}
+	responseWriter := &noopResponseWriter{}
+	for _, p := range paths {
+		for _, name := range routerNames {
+			router := routers[name]
+			b.Run(p.name+"/"+name, func(b *testing.B) {
+				b.ReportAllocs()
+				request, err := http.NewRequest(p.method, p.path, &bytes.Buffer{})
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					router.ServeHTTP(responseWriter, request)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkWebappManyRoutes(b *testing.B) {
+	m := webapp.NewMuxer(&webapp.MuxerConfig{
+		Logging: webapp.LevelOff,
+	})
+	noop := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for i := 0; i < 100; i++ {
+		m.Get(fmt.Sprintf("/static/route/%d", i), noop)
+		m.Get(fmt.Sprintf("/api/v%d/widgets/:slug", i), noop)
+		m.Post(fmt.Sprintf("/api/v%d/widgets/:slug/parts/:id|int/update", i), noop)
+		m.Get(fmt.Sprintf("/files/v%d/*path", i), noop)
+		m.Get(fmt.Sprintf("/prefix/v%d/", i), noop)
+	}
+	paths := []struct {
+		name   string
+		method string
+		path   string
+	}{
+		{"static", "GET", "/static/route/99"},
+		{"param", "GET", "/api/v99/widgets/foo"},
+		{"deep", "POST", "/api/v99/widgets/foo/parts/1/update"},
+		{"wildcard", "GET", "/files/v99/a/b/c.txt"},
+		{"prefix", "GET", "/prefix/v99/a/b"},
+		{"not-found", "GET", "/api/v99/gadgets/foo"},
+	}
+	responseWriter := &noopResponseWriter{}
+	for _, p := range paths {
+		b.Run(p.name, func(b *testing.B) {
+			b.ReportAllocs()
+			request, err := http.NewRequest(p.method, p.path, &bytes.Buffer{})
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				m.ServeHTTP(responseWriter, request)
+			}
+		})
+	}
+}
+
+type noopResponseWriter struct {
+	header http.Header
+}
 
 func (r *noopResponseWriter) Header() http.Header {
-	return nil
+	if r.header == nil {
+		r.header = make(http.Header)
+	}
+	return r.header
 }
 
 func (r *noopResponseWriter) Write(b []byte) (int, error) {
@@ -18,8 +18,8 @@ var httpHandlerRoutes = []struct {
 	{"POST", "/api/widgets", apiCreateWidget},
 	{"POST", "/api/widgets/:slug", apiUpdateWidget},
 	{"POST", "/api/widgets/:slug/parts", apiCreateWidgetPart},
-	{"POST", "/api/widgets/:slug/parts/:id/update", apiUpdateWidgetPart},
-	{"POST", "/api/widgets/:slug/parts/:id/delete", apiDeleteWidgetPart},
+	{"POST", "/api/widgets/:slug/parts/:id|int/update", apiUpdateWidgetPart},
+	{"POST", "/api/widgets/:slug/parts/:id|int/delete", apiDeleteWidgetPart},
 	{"GET", "/:slug", widget},
 	{"GET", "/:slug/admin", widgetAdmin},
 	{"POST", "/:slug/image", widgetImage},
@@ -39,7 +39,7 @@ func Makewebappmuxer() *webapp.Muxer {
 }
 
 func getField(r *http.Request, key string) string {
-	return r.FormValue(key)
+	return webapp.Param(r, key)
 }
 
 func home(w http.ResponseWriter, r *http.Request) {
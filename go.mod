@@ -4,3 +4,9 @@ go 1.17
 
 // go sqlite driver
 require github.com/mattn/go-sqlite3 v1.14.10
+
+// password hashing (argon2id, bcrypt)
+require (
+	golang.org/x/crypto v0.14.0
+	golang.org/x/sys v0.13.0 // indirect
+)
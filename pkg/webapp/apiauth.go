@@ -0,0 +1,219 @@
+package webapp
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// userKey and tokenKey are the context keys for the user and API token
+// of a request authenticated by APIAuth
+type (
+	userKey  struct{}
+	tokenKey struct{}
+)
+
+// UserFrom returns the user of a request that has been authenticated by
+// APIAuth, without the password hash, or nil if it has not been
+func UserFrom(r *http.Request) *SystemUser {
+	user, _ := r.Context().Value(userKey{}).(*SystemUser)
+	return user
+}
+
+// TokenFrom returns the API token of a request that has been
+// authenticated with a Bearer token, or nil if it has not been
+func TokenFrom(r *http.Request) *APIToken {
+	t, _ := r.Context().Value(tokenKey{}).(*APIToken)
+	return t
+}
+
+// APIAuthConfig is a configuration object for an APIAuth
+type APIAuthConfig struct {
+	Users    AuthUser    // Users checks Basic credentials, and must be a UserLookup for Tokens
+	Tokens   *TokenStore // Tokens checks Bearer tokens, which are not accepted if nil
+	Realm    string      // Realm is the realm of the authentication challenge, the default is "webapp"
+	Guard    *LoginGuard // Guard locks out failing Basic logins, the default is a LoginGuard with the default config
+	Optional bool        // Optional lets requests without credentials through, unauthenticated
+	Muxer    *Muxer      // Muxer renders errors and logs lockouts, it may be nil
+}
+
+// APIAuth provides middleware authenticating requests by their
+// Authorization header, for APIs and other clients without sessions. It
+// accepts HTTP Basic credentials, checked against any AuthUser, and
+// Bearer API tokens issued by a TokenStore. The user is put into the
+// request context (see UserFrom), where the Authorizer also looks.
+//
+// Keep in mind that Basic credentials are hashed on every request, which
+// is slow by design, so clients making many requests should use tokens.
+type APIAuth struct {
+	*APIAuthConfig
+	lookup UserLookup
+}
+
+// NewAPIAuth returns a new API authenticator
+func NewAPIAuth(conf *APIAuthConfig) *APIAuth {
+	if conf == nil || conf.Users == nil {
+		panic("auth: APIAuth requires users")
+	}
+	if conf.Realm == "" {
+		conf.Realm = "webapp"
+	}
+	if conf.Guard == nil {
+		gc := new(LoginGuardConfig)
+		if conf.Muxer != nil {
+			gc.Logger = conf.Muxer.Logger()
+		}
+		conf.Guard = NewLoginGuard(gc)
+	}
+	aa := &APIAuth{APIAuthConfig: conf}
+	if conf.Tokens != nil {
+		lookup, ok := conf.Users.(UserLookup)
+		if !ok {
+			panic("auth: APIAuth tokens require users implementing UserLookup")
+		}
+		aa.lookup = lookup
+	}
+	return aa
+}
+
+// APIAuth returns an API authenticator for the users of the web app,
+// sharing its login guard, and accepting tokens from the token store if
+// it is not nil
+func (app *WebApp) APIAuth(tokens *TokenStore) *APIAuth {
+	if app.AuthUser == nil {
+		panic("webapp: APIAuth requires users")
+	}
+	conf := &APIAuthConfig{
+		Users:  app.AuthUser,
+		Tokens: tokens,
+		Muxer:  app.Muxer,
+	}
+	if app.auth != nil {
+		conf.Guard = app.auth.Guard
+	}
+	return NewAPIAuth(conf)
+}
+
+// Middleware returns middleware authenticating each request. Requests
+// with missing or wrong credentials get a 401, with a challenge for each
+// accepted scheme, unless credentials are Optional and there are none.
+// Failing Basic logins are counted by the Guard, and get a 429 with a
+// Retry-After header while the username or address is locked out.
+func (aa *APIAuth) Middleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			h := r.Header.Get("Authorization")
+			if h == "" {
+				if aa.Optional {
+					next.ServeHTTP(w, r)
+					return
+				}
+				aa.challenge(w, r, "")
+				return
+			}
+			scheme, creds := h, ""
+			if i := strings.IndexByte(h, ' '); i >= 0 {
+				scheme, creds = h[:i], strings.TrimSpace(h[i+1:])
+			}
+			var user *SystemUser
+			var token *APIToken
+			switch {
+			case strings.EqualFold(scheme, "Basic"):
+				un, pw, ok := r.BasicAuth()
+				if !ok {
+					aa.challenge(w, r, "")
+					return
+				}
+				// clients send their credentials with every request, so
+				// only lockouts are enforced, not the wait between logins
+				addr := remoteAddr(r)
+				if wait, locked := aa.Guard.LockedOut(un, addr); locked {
+					w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+					aa.error(w, r, http.StatusTooManyRequests)
+					return
+				}
+				user, ok = aa.Users.Authenticate(un, pw)
+				if !ok {
+					aa.Guard.CountFailure(un, addr)
+					aa.challenge(w, r, "")
+					return
+				}
+			case strings.EqualFold(scheme, "Bearer") && aa.Tokens != nil:
+				var err error
+				if token, err = aa.Tokens.Verify(creds); err != nil {
+					aa.challenge(w, r, "invalid_token")
+					return
+				}
+				user, err = aa.lookup.User(token.Username)
+				if err != nil || !user.IsActive {
+					aa.challenge(w, r, "invalid_token")
+					return
+				}
+			default:
+				aa.challenge(w, r, "")
+				return
+			}
+			u := *user
+			u.Password = ""
+			ctx := context.WithValue(r.Context(), userKey{}, &u)
+			if token != nil {
+				ctx = context.WithValue(ctx, tokenKey{}, token)
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// RequireScope returns middleware only letting requests through whose
+// Bearer token has all of the scopes. It must come after the APIAuth
+// middleware. Requests authenticated with Basic credentials are not
+// limited by scopes, as they have the password of the user.
+func (aa *APIAuth) RequireScope(scopes ...string) Middleware {
+	if len(scopes) == 0 {
+		panic("auth: RequireScope requires a scope")
+	}
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if UserFrom(r) == nil {
+				aa.challenge(w, r, "")
+				return
+			}
+			if t := TokenFrom(r); t != nil {
+				for _, scope := range scopes {
+					if !t.HasScope(scope) {
+						w.Header().Set("WWW-Authenticate", `Bearer realm="`+aa.Realm+`", error="insufficient_scope", scope="`+strings.Join(scopes, " ")+`"`)
+						aa.error(w, r, http.StatusForbidden)
+						return
+					}
+				}
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// challenge writes a 401, asking for credentials in each accepted scheme,
+// with the error for the Bearer scheme if one was given
+func (aa *APIAuth) challenge(w http.ResponseWriter, r *http.Request, bearerError string) {
+	w.Header().Add("WWW-Authenticate", `Basic realm="`+aa.Realm+`", charset="UTF-8"`)
+	if aa.Tokens != nil {
+		c := `Bearer realm="` + aa.Realm + `"`
+		if bearerError != "" {
+			c += `, error="` + bearerError + `"`
+		}
+		w.Header().Add("WWW-Authenticate", c)
+	}
+	aa.error(w, r, http.StatusUnauthorized)
+}
+
+func (aa *APIAuth) error(w http.ResponseWriter, r *http.Request, code int) {
+	if aa.Muxer == nil {
+		DefaultErrorRenderer(w, r, code)
+		return
+	}
+	aa.Muxer.Error(w, r, code)
+}
@@ -0,0 +1,158 @@
+package webapp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAPIAuth(t *testing.T) {
+	users := NewSystemSessionUser()
+	users.Hasher = NewBcryptHasher(4)
+	users.Register("bob", "correct horse", RoleUser)
+	users.Register("eve", "correct horse", RoleUser)
+	ts := NewTokenStore(nil)
+	read, _, _ := ts.Issue("bob", []string{"read"}, time.Hour)
+	both, _, _ := ts.Issue("bob", []string{"read", "write"}, time.Hour)
+	short, _, _ := ts.Issue("bob", []string{"write"}, 20*time.Millisecond)
+	revoked, rec, _ := ts.Issue("bob", []string{"write"}, time.Hour)
+	ts.Revoke(rec.ID)
+	disabled, _, _ := ts.Issue("eve", []string{"write"}, time.Hour)
+	v, _ := users.users.Load("eve")
+	v.(*SystemUser).IsActive = false
+	time.Sleep(30 * time.Millisecond)
+
+	aa := NewAPIAuth(&APIAuthConfig{Users: users, Tokens: ts})
+	h := NewChain(aa.Middleware(), aa.RequireScope("write")).ThenFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := UserFrom(r)
+		if user.Password != "" {
+			t.Error("user in the context has the password hash")
+		}
+		w.Write([]byte(user.Username))
+	})
+	basic := func(un, pw string) string {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.SetBasicAuth(un, pw)
+		return r.Header.Get("Authorization")
+	}
+	tests := []struct {
+		name      string
+		auth      string
+		want      int
+		challenge string
+	}{
+		{"no credentials", "", http.StatusUnauthorized, `Basic realm="webapp"`},
+		{"basic", basic("bob", "correct horse"), http.StatusOK, ""},
+		{"basic with the wrong password", basic("al", "wrong"), http.StatusUnauthorized, `Basic realm="webapp"`},
+		{"bearer with the scope", "Bearer " + both, http.StatusOK, ""},
+		{"bearer scheme in lower case", "bearer " + both, http.StatusOK, ""},
+		{"bearer without the scope", "Bearer " + read, http.StatusForbidden, `error="insufficient_scope"`},
+		{"expired bearer", "Bearer " + short, http.StatusUnauthorized, `error="invalid_token"`},
+		{"revoked bearer", "Bearer " + revoked, http.StatusUnauthorized, `error="invalid_token"`},
+		{"bearer of a disabled user", "Bearer " + disabled, http.StatusUnauthorized, `error="invalid_token"`},
+		{"unknown bearer", "Bearer wat_unknown", http.StatusUnauthorized, `error="invalid_token"`},
+		{"unknown scheme", "Digest foo", http.StatusUnauthorized, `Bearer realm="webapp"`},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		if tt.auth != "" {
+			r.Header.Set("Authorization", tt.auth)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.want)
+		}
+		if tt.want == http.StatusOK && w.Body.String() != "bob" {
+			t.Errorf("%s: user %q", tt.name, w.Body.String())
+		}
+		challenge := strings.Join(w.Header()["Www-Authenticate"], ", ")
+		if !strings.Contains(challenge, tt.challenge) {
+			t.Errorf("%s: challenge %q, want %q", tt.name, challenge, tt.challenge)
+		}
+	}
+}
+
+func TestAPIAuthGuard(t *testing.T) {
+	users := NewSystemSessionUser()
+	users.Hasher = NewBcryptHasher(4)
+	users.Register("bob", "correct horse", RoleUser)
+	aa := NewAPIAuth(&APIAuthConfig{Users: users, Guard: NewLoginGuard(&LoginGuardConfig{MaxFailures: 3, BaseDelay: time.Minute})})
+	h := aa.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tests := []struct {
+		password string
+		want     int
+	}{
+		{"wrong", http.StatusUnauthorized},
+		// no wait after a failure, as clients send credentials every time
+		{"correct horse", http.StatusOK},
+		{"wrong", http.StatusUnauthorized},
+		{"wrong", http.StatusUnauthorized},
+		{"correct horse", http.StatusTooManyRequests},
+	}
+	for i, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.SetBasicAuth("bob", tt.password)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("request %d: status %d, want %d", i+1, w.Code, tt.want)
+		}
+	}
+}
+
+func TestAPIAuthConcurrentBasic(t *testing.T) {
+	users := NewSystemSessionUser()
+	users.Hasher = NewBcryptHasher(4)
+	users.Register("bob", "correct horse", RoleUser)
+	guard := NewLoginGuard(&LoginGuardConfig{BaseDelay: time.Minute})
+	aa := NewAPIAuth(&APIAuthConfig{Users: users, Guard: guard})
+	h := aa.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	codes := make([]int, 8)
+	var wg sync.WaitGroup
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r := httptest.NewRequest("GET", "/", nil)
+			r.SetBasicAuth("bob", "correct horse")
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			codes[i] = w.Code
+		}(i)
+	}
+	wg.Wait()
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("request %d: status %d, want 200", i+1, code)
+		}
+	}
+	// nor do they hold up logins sharing the guard
+	if _, ok := guard.Check("bob", "192.0.2.1"); !ok {
+		t.Error("login refused after API requests")
+	}
+}
+
+func TestAPIAuthOptionalAndAuthorizer(t *testing.T) {
+	users := NewSystemSessionUser()
+	users.Hasher = NewBcryptHasher(4)
+	users.Register("root", "correct horse", RoleAdmin)
+	aa := NewAPIAuth(&APIAuthConfig{Users: users, Optional: true})
+	h := NewChain(aa.Middleware(), NewAuthorizer(nil).RequireRole(RoleAdmin)).ThenFunc(func(w http.ResponseWriter, r *http.Request) {})
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous request: status %d, want the authorizer's 401", w.Code)
+	}
+	r.SetBasicAuth("root", "correct horse")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("admin request: status %d, want 200", w.Code)
+	}
+}
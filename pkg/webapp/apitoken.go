@@ -0,0 +1,155 @@
+package webapp
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrInvalidToken is returned for API tokens that are unknown, revoked
+// or expired
+var ErrInvalidToken = errors.New("auth: invalid or expired token")
+
+// apiTokenPrefix starts every API token, which makes them easy to spot,
+// eg. by secret scanners
+const apiTokenPrefix = "wat_"
+
+// APIToken is an issued API token, as it is stored. The token itself is
+// never stored, only its hash, which is the ID.
+type APIToken struct {
+	ID       string    `json:"id"` // ID is the hash of the token, it can be shown and used to revoke it
+	Username string    `json:"username"`
+	Scopes   []string  `json:"scopes"`
+	Created  time.Time `json:"created"`
+	Expires  time.Time `json:"expires"`
+}
+
+// HasScope reports whether the token has been granted the scope
+func (t *APIToken) HasScope(scope string) bool {
+	return grants(t.Scopes, scope)
+}
+
+// TokenStoreConfig is a configuration object for a TokenStore
+type TokenStoreConfig struct {
+	Store  SessionKV     // Store keeps the tokens, they are kept in memory if nil
+	MaxAge time.Duration // MaxAge is how long tokens last when issued without an expiry, the default is 90 days
+	Logger *Logger       // Logger reports store errors, if set
+}
+
+// TokenStore issues and checks opaque API tokens, for the Bearer scheme
+// of APIAuth. A token is a random string, which is only shown when it is
+// issued. Tokens are stored under their SHA-256 hash, so the store can't
+// be used to make tokens, along with the user they were issued to, the
+// scopes they grant and when they expire.
+type TokenStore struct {
+	*TokenStoreConfig
+}
+
+// NewTokenStore returns a new token store
+func NewTokenStore(conf *TokenStoreConfig) *TokenStore {
+	if conf == nil {
+		conf = new(TokenStoreConfig)
+	}
+	if conf.Store == nil {
+		conf.Store = NewMemorySessionKV()
+	}
+	if conf.MaxAge == 0 {
+		conf.MaxAge = time.Duration(90*24) * time.Hour
+	}
+	ts := &TokenStore{TokenStoreConfig: conf}
+	go ts.gc()
+	return ts
+}
+
+// Issue returns a new token for the user, granting the scopes, which
+// expires after ttl, or the MaxAge if ttl is zero. The token must be
+// handed to the user now, as it can't be got back later.
+func (ts *TokenStore) Issue(username string, scopes []string, ttl time.Duration) (string, *APIToken, error) {
+	if username == "" {
+		return "", nil, ErrInvalidUsername
+	}
+	if ttl <= 0 {
+		ttl = ts.MaxAge
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, fmt.Errorf("auth: reading random bytes: %w", err)
+	}
+	token := apiTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	now := time.Now()
+	t := &APIToken{
+		ID:       tokenID(token),
+		Username: username,
+		Scopes:   append([]string(nil), scopes...),
+		Created:  now,
+		Expires:  now.Add(ttl),
+	}
+	v, err := json.Marshal(t)
+	if err != nil {
+		return "", nil, fmt.Errorf("auth: encoding token: %w", err)
+	}
+	if err := ts.Store.Set(t.ID, v, t.Expires); err != nil {
+		return "", nil, fmt.Errorf("auth: storing token: %w", err)
+	}
+	return token, t, nil
+}
+
+// Verify returns the stored token, or ErrInvalidToken
+func (ts *TokenStore) Verify(token string) (*APIToken, error) {
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		return nil, ErrInvalidToken
+	}
+	return ts.Lookup(tokenID(token))
+}
+
+// Lookup returns the token with the ID, or ErrInvalidToken
+func (ts *TokenStore) Lookup(id string) (*APIToken, error) {
+	v, err := ts.Store.Get(id)
+	if err != nil {
+		if !errors.Is(err, ErrSessionNotFound) {
+			ts.logError("loading token", err)
+		}
+		return nil, ErrInvalidToken
+	}
+	t := new(APIToken)
+	if err := json.Unmarshal(v, t); err != nil {
+		ts.logError("decoding token", err)
+		return nil, ErrInvalidToken
+	}
+	if !time.Now().Before(t.Expires) {
+		return nil, ErrInvalidToken
+	}
+	return t, nil
+}
+
+// Revoke removes the token with the ID
+func (ts *TokenStore) Revoke(id string) error {
+	return ts.Store.Delete(id)
+}
+
+// tokenID returns the ID, the hash, of a token
+func tokenID(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
+
+// gc removes expired tokens from stores that can do so, every hour
+func (ts *TokenStore) gc() {
+	if e, ok := ts.Store.(SessionExpirer); ok {
+		if err := e.DeleteExpired(); err != nil {
+			ts.logError("deleting expired tokens", err)
+		}
+	}
+	time.AfterFunc(time.Hour, func() { ts.gc() })
+}
+
+func (ts *TokenStore) logError(what string, err error) {
+	if ts.Logger != nil {
+		ts.Logger.Error("auth: tokens: %s: %s\n", what, err)
+	}
+}
@@ -0,0 +1,68 @@
+package webapp
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenStore(t *testing.T) {
+	ts := NewTokenStore(nil)
+	token, issued, err := ts.Issue("bob", []string{"read"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(token, apiTokenPrefix) || strings.Contains(issued.ID, token) {
+		t.Fatalf("unexpected token %q, id %q", token, issued.ID)
+	}
+	// a token that has expired, though the store still holds it
+	expired := &APIToken{ID: tokenID(apiTokenPrefix + "old"), Username: "bob", Expires: time.Now().Add(-time.Minute)}
+	b, _ := json.Marshal(expired)
+	ts.Store.Set(expired.ID, b, time.Now().Add(time.Hour))
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"issued", token, nil},
+		{"tampered", token[:len(token)-1] + "x", ErrInvalidToken},
+		{"no prefix", strings.TrimPrefix(token, apiTokenPrefix), ErrInvalidToken},
+		{"the id", issued.ID, ErrInvalidToken},
+		{"expired", apiTokenPrefix + "old", ErrInvalidToken},
+		{"empty", "", ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		got, err := ts.Verify(tt.token)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+			continue
+		}
+		if err == nil && (got.Username != "bob" || !got.HasScope("read") || got.HasScope("write")) {
+			t.Errorf("%s: got %+v", tt.name, got)
+		}
+	}
+
+	if err := ts.Revoke(issued.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.Verify(token); !errors.Is(err, ErrInvalidToken) {
+		t.Error("revoked token accepted")
+	}
+}
+
+func TestTokenStoreDefaults(t *testing.T) {
+	ts := NewTokenStore(&TokenStoreConfig{MaxAge: time.Hour})
+	_, issued, err := ts.Issue("bob", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Until(issued.Expires); d <= 59*time.Minute || d > time.Hour {
+		t.Errorf("token without a ttl expires in %s, want the MaxAge", d)
+	}
+	if _, _, err := ts.Issue("", nil, 0); !errors.Is(err, ErrInvalidUsername) {
+		t.Errorf("token issued without a username: %v", err)
+	}
+}
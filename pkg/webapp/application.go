@@ -33,9 +33,8 @@ type ApplicationConfig struct {
 var defaultApplicationConfig = &ApplicationConfig{
 	SystemSessionUser: NewBasicAuthUser(),
 	SessionConfig: &SessionConfig{
-		SessionID: "go_sess_id",
-		Domain:    "localhost",
-		Timeout:   time.Duration(15) * time.Minute,
+		SessionID:   "go_sess_id",
+		IdleTimeout: time.Duration(15) * time.Minute,
 	},
 	TemplateConfig: &TemplateConfig{
 		BasePattern:   "web/templates/*.html",
@@ -1,20 +1,53 @@
 package webapp
 
-import "sync"
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	// ErrUserExists is returned when registering a username that is taken
+	ErrUserExists = errors.New("auth: username already registered")
+
+	// ErrUserNotFound is returned when there is no user with the username
+	ErrUserNotFound = errors.New("auth: user not found")
+
+	// ErrInvalidUsername is returned when registering an empty username
+	ErrInvalidUsername = errors.New("auth: invalid username")
+)
 
 type AuthUser interface {
-	Register(username, password, role string)
+	// Register adds a new user, the username must not be taken
+	Register(username, password, role string) error
+
+	// Authenticate returns the user if the password is correct, and
+	// the account is active
 	Authenticate(username, password string) (*SystemUser, bool)
 }
 
 type SystemUser struct {
+	ID       int
 	Username string
-	Password string
+	Password string // Password is the encoded password hash
 	Role     string
+	IsActive bool // IsActive is false for disabled accounts
+}
+
+// GetID helps satisfy the Entity interface
+func (su *SystemUser) GetID() int {
+	return su.ID
 }
 
+// SetID helps satisfy the Entity interface
+func (su *SystemUser) SetID(id int) {
+	su.ID = id
+}
+
+// SystemSessionUser is the in memory AuthUser implementation. Passwords
+// are hashed with the Hasher, or the DefaultPasswordHasher if it is nil.
 type SystemSessionUser struct {
-	users *sync.Map
+	Hasher PasswordHasher
+	users  *sync.Map
 }
 
 func NewSystemSessionUser() *SystemSessionUser {
@@ -23,21 +56,77 @@ func NewSystemSessionUser() *SystemSessionUser {
 	}
 }
 
-func (a *SystemSessionUser) Register(username, password, role string) {
-	a.users.Store(username, &SystemUser{
+// NewBasicAuthUser returns a new SystemSessionUser, which
+// is the basic (in memory) auth user implementation
+func NewBasicAuthUser() *SystemSessionUser {
+	return NewSystemSessionUser()
+}
+
+func (a *SystemSessionUser) hasher() PasswordHasher {
+	if a.Hasher == nil {
+		return DefaultPasswordHasher
+	}
+	return a.Hasher
+}
+
+func (a *SystemSessionUser) Register(username, password, role string) error {
+	if username == "" {
+		return ErrInvalidUsername
+	}
+	if _, ok := a.users.Load(username); ok {
+		return ErrUserExists
+	}
+	hash, err := a.hasher().Hash(password)
+	if err != nil {
+		return err
+	}
+	_, loaded := a.users.LoadOrStore(username, &SystemUser{
 		Username: username,
-		Password: password,
+		Password: hash,
 		Role:     role,
+		IsActive: true,
 	})
+	if loaded {
+		return ErrUserExists
+	}
+	return nil
 }
 
+// Authenticate checks the password of the user, and replaces the stored
+// hash with a new one if the hasher's parameters have changed. The user
+// returned is a copy, without the password hash.
 func (a *SystemSessionUser) Authenticate(username, password string) (*SystemUser, bool) {
-	su, ok := a.users.Load(username)
+	v, ok := a.users.Load(username)
 	if !ok {
+		wastePasswordCheck(a.hasher(), password)
 		return nil, false
 	}
-	if su.(*SystemUser).Password != password {
+	su := v.(*SystemUser)
+	ok, rehash := checkPassword(a.hasher(), password, su.Password)
+	if !ok || !su.IsActive {
 		return nil, false
 	}
-	return su.(*SystemUser), true
+	if rehash {
+		if hash, err := a.hasher().Hash(password); err == nil {
+			nsu := *su
+			nsu.Password = hash
+			a.users.Store(username, &nsu)
+		}
+	}
+	// return a copy, without the password hash
+	cp := *su
+	cp.Password = ""
+	return &cp, true
+}
+
+// User returns a copy of the user with the username, without the
+// password hash, or ErrUserNotFound
+func (a *SystemSessionUser) User(username string) (*SystemUser, error) {
+	v, ok := a.users.Load(username)
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	cp := *v.(*SystemUser)
+	cp.Password = ""
+	return &cp, nil
 }
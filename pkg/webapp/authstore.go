@@ -0,0 +1,241 @@
+package webapp
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// ErrUnknownRole is returned when registering a user with a role
+// the AuthUser does not know about
+var ErrUnknownRole = errors.New("auth: unknown role")
+
+// DataAuthUser is an AuthUser keeping its users in a DataAccesser, so
+// they persist as long as the data source does. The data accesser must
+// store *SystemUser entities, and should not hold anything else.
+// Passwords are hashed with the Hasher, or the DefaultPasswordHasher if
+// it is nil.
+//
+// Usernames are unique, which is checked on Register. If the data source
+// is shared by several processes it should enforce this itself too.
+//
+// Users are looked up by id, through an index of the usernames that is
+// loaded from the data source when it is first needed, and kept up to
+// date by Register and Delete. Users added to the data source some
+// other way are not found until the index is reloaded with Reindex.
+type DataAuthUser struct {
+	Hasher PasswordHasher
+	dao    DataAccesser
+	roles  map[string]bool
+	mu     sync.Mutex     // mu serializes changes to the users
+	ids    map[string]int // ids maps usernames to ids, nil until loaded
+	idsMu  sync.RWMutex
+}
+
+// NewDataAuthUser returns an AuthUser storing users with the supplied
+// data accesser. Users may only have one of the supplied roles, or
+// RoleUser and RoleAdmin if there are none.
+func NewDataAuthUser(dao DataAccesser, roles ...string) *DataAuthUser {
+	if dao == nil {
+		panic("auth: DataAuthUser requires a data accesser")
+	}
+	if len(roles) == 0 {
+		roles = []string{RoleUser, RoleAdmin}
+	}
+	a := &DataAuthUser{
+		dao:   dao,
+		roles: make(map[string]bool),
+	}
+	for _, role := range roles {
+		a.roles[role] = true
+	}
+	return a
+}
+
+func (a *DataAuthUser) hasher() PasswordHasher {
+	if a.Hasher == nil {
+		return DefaultPasswordHasher
+	}
+	return a.Hasher
+}
+
+// Register adds a new active user. It returns ErrUserExists if the
+// username is taken, and ErrUnknownRole if the role is not known.
+func (a *DataAuthUser) Register(username, password, role string) error {
+	if username == "" {
+		return ErrInvalidUsername
+	}
+	if !a.roles[role] {
+		return fmt.Errorf("%w: %q", ErrUnknownRole, role)
+	}
+	hash, err := a.hasher().Hash(password)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err = a.find(username)
+	if err == nil {
+		return ErrUserExists
+	}
+	if !errors.Is(err, ErrUserNotFound) {
+		return err
+	}
+	id, err := a.dao.Add(&SystemUser{
+		Username: username,
+		Password: hash,
+		Role:     role,
+		IsActive: true,
+	})
+	if err != nil {
+		return err
+	}
+	a.idsMu.Lock()
+	a.ids[username] = id
+	a.idsMu.Unlock()
+	return nil
+}
+
+// Authenticate returns the user if the password is correct and the
+// account is active. The stored hash is replaced with a new one if the
+// hasher's parameters have changed. The user returned is a copy, without
+// the password hash.
+func (a *DataAuthUser) Authenticate(username, password string) (*SystemUser, bool) {
+	su, err := a.find(username)
+	if err != nil {
+		wastePasswordCheck(a.hasher(), password)
+		return nil, false
+	}
+	ok, rehash := checkPassword(a.hasher(), password, su.Password)
+	if !ok || !su.IsActive {
+		return nil, false
+	}
+	if rehash {
+		if hash, err := a.hasher().Hash(password); err == nil {
+			_ = a.update(username, func(su *SystemUser) { su.Password = hash })
+		}
+	}
+	su.Password = ""
+	return su, true
+}
+
+// User returns a copy of the user with the username, without the
+// password hash, or ErrUserNotFound
+func (a *DataAuthUser) User(username string) (*SystemUser, error) {
+	su, err := a.find(username)
+	if err != nil {
+		return nil, err
+	}
+	su.Password = ""
+	return su, nil
+}
+
+// SetActive enables or disables the account of the user. Disabled
+// users can't log in, but keep their data.
+func (a *DataAuthUser) SetActive(username string, active bool) error {
+	return a.update(username, func(su *SystemUser) { su.IsActive = active })
+}
+
+// SetRole changes the role of the user
+func (a *DataAuthUser) SetRole(username, role string) error {
+	if !a.roles[role] {
+		return fmt.Errorf("%w: %q", ErrUnknownRole, role)
+	}
+	return a.update(username, func(su *SystemUser) { su.Role = role })
+}
+
+// SetPassword changes the password of the user
+func (a *DataAuthUser) SetPassword(username, password string) error {
+	hash, err := a.hasher().Hash(password)
+	if err != nil {
+		return err
+	}
+	return a.update(username, func(su *SystemUser) { su.Password = hash })
+}
+
+// Delete removes the user
+func (a *DataAuthUser) Delete(username string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	su, err := a.find(username)
+	if err != nil {
+		return err
+	}
+	if err := a.dao.Del(su.ID); err != nil {
+		return err
+	}
+	a.idsMu.Lock()
+	delete(a.ids, username)
+	a.idsMu.Unlock()
+	return nil
+}
+
+// Reindex reloads the index of usernames from the data source, for
+// when users have been added to it other than through Register
+func (a *DataAuthUser) Reindex() error {
+	ee, err := a.dao.GetAll()
+	if err != nil {
+		return err
+	}
+	ids := make(map[string]int, len(ee))
+	for _, e := range ee {
+		su, ok := e.(*SystemUser)
+		if !ok {
+			return fmt.Errorf("auth: data accesser holds a %T, not a *SystemUser", e)
+		}
+		ids[su.Username] = su.ID
+	}
+	a.idsMu.Lock()
+	a.ids = ids
+	a.idsMu.Unlock()
+	return nil
+}
+
+// update applies fn to a copy of the user, and stores the copy
+func (a *DataAuthUser) update(username string, fn func(su *SystemUser)) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	su, err := a.find(username)
+	if err != nil {
+		return err
+	}
+	fn(su)
+	return a.dao.Set(su)
+}
+
+// find returns a copy of the user with the username, so that the stored
+// user is never changed in place (a memory data source stores pointers)
+func (a *DataAuthUser) find(username string) (*SystemUser, error) {
+	a.idsMu.RLock()
+	loaded := a.ids != nil
+	id, ok := a.ids[username]
+	a.idsMu.RUnlock()
+	if !loaded {
+		if err := a.Reindex(); err != nil {
+			return nil, err
+		}
+		return a.find(username)
+	}
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	e, err := a.dao.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	su, ok := e.(*SystemUser)
+	if !ok {
+		return nil, fmt.Errorf("auth: data accesser holds a %T, not a *SystemUser", e)
+	}
+	if su.Username != username {
+		// changed in the data source behind our back
+		return nil, ErrUserNotFound
+	}
+	cp := *su
+	return &cp, nil
+}
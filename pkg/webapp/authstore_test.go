@@ -0,0 +1,111 @@
+package webapp
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+// countingDAO is an in memory DataAccesser counting full scans
+type countingDAO struct {
+	mu    sync.Mutex
+	data  map[int]Entity
+	next  int
+	scans int
+}
+
+func (d *countingDAO) Add(e Entity) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.next++
+	e.SetID(d.next)
+	d.data[d.next] = e
+	return d.next, nil
+}
+
+func (d *countingDAO) Get(id int) (Entity, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e, ok := d.data[id]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return e, nil
+}
+
+func (d *countingDAO) GetAll() ([]Entity, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.scans++
+	var ee []Entity
+	for _, e := range d.data {
+		ee = append(ee, e)
+	}
+	return ee, nil
+}
+
+func (d *countingDAO) Set(e Entity) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.data[e.GetID()] = e
+	return nil
+}
+
+func (d *countingDAO) Del(id int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.data, id)
+	return nil
+}
+
+func TestDataAuthUser(t *testing.T) {
+	dao := &countingDAO{data: make(map[int]Entity)}
+	// a user already in the data source is found through the index
+	dao.Add(&SystemUser{Username: "old", Role: RoleUser, IsActive: true})
+	users := NewDataAuthUser(dao)
+	users.Hasher = NewBcryptHasher(4)
+	for _, name := range []string{"bob", "eve"} {
+		if err := users.Register(name, "correct horse", RoleUser); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := users.Register("bob", "other", RoleUser); !errors.Is(err, ErrUserExists) {
+		t.Errorf("duplicate username: %v", err)
+	}
+	if _, ok := users.Authenticate("bob", "correct horse"); !ok {
+		t.Error("bob not authenticated")
+	}
+	if _, ok := users.Authenticate("nobody", "correct horse"); ok {
+		t.Error("unknown user authenticated")
+	}
+	if _, err := users.User("old"); err != nil {
+		t.Errorf("existing user: %v", err)
+	}
+	if err := users.SetActive("eve", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := users.Authenticate("eve", "correct horse"); ok {
+		t.Error("disabled user authenticated")
+	}
+	if err := users.Delete("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.User("bob"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("deleted user: %v", err)
+	}
+	if dao.scans != 1 {
+		t.Errorf("%d full scans of the data source, want 1", dao.scans)
+	}
+
+	// users added behind its back are found after a Reindex
+	dao.Add(&SystemUser{Username: "new", Role: RoleUser, IsActive: true})
+	if _, err := users.User("new"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("user found before reindexing: %v", err)
+	}
+	if err := users.Reindex(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.User("new"); err != nil {
+		t.Errorf("user not found after reindexing: %v", err)
+	}
+}
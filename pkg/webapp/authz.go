@@ -0,0 +1,260 @@
+package webapp
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// SessionUsernameKey is the session key the logged in username is
+	// stored under, a session without it is not logged in
+	SessionUsernameKey = "username"
+
+	// SessionRoleKey is the session key the role of the user is stored under
+	SessionRoleKey = "role"
+)
+
+// AuthzConfig is a configuration object for an Authorizer
+type AuthzConfig struct {
+	LoginURL    string              // LoginURL is where users who are not logged in are sent, the default is "/login"
+	ReturnParam string              // ReturnParam is the login URL parameter holding the page to return to, the default is "next"
+	Roles       map[string][]string // Roles maps roles to the roles they include, the default is admin ⊇ user
+	Permissions map[string][]string // Permissions maps roles to the permissions they are granted
+	Sessions    SessionManager      // Sessions is used for requests that are not through the session middleware
+	Muxer       *Muxer              // Muxer renders errors and logs denied requests, it may be nil
+}
+
+// Authorizer provides middleware restricting routes to logged in users,
+// and to users with particular roles or permissions. The user is the
+// one authenticated by APIAuth (see UserFrom), or else the one stored in
+// the session by WebApp's login handlers, under the SessionUsernameKey
+// and SessionRoleKey keys.
+//
+// Roles form a hierarchy, where a role includes any roles it is mapped to
+// in AuthzConfig.Roles, and in turn the roles they include. A role has
+// the permissions granted to it and to all the roles it includes.
+type Authorizer struct {
+	*AuthzConfig
+	includes map[string]map[string]bool // includes is the closure of Roles
+}
+
+// NewAuthorizer returns a new authorizer. Roles that include each other
+// cause a panic.
+func NewAuthorizer(conf *AuthzConfig) *Authorizer {
+	if conf == nil {
+		conf = new(AuthzConfig)
+	}
+	if conf.LoginURL == "" {
+		conf.LoginURL = "/login"
+	}
+	if conf.ReturnParam == "" {
+		conf.ReturnParam = "next"
+	}
+	if conf.Roles == nil {
+		conf.Roles = map[string][]string{RoleAdmin: {RoleUser}}
+	}
+	az := &Authorizer{
+		AuthzConfig: conf,
+		includes:    make(map[string]map[string]bool),
+	}
+	for role := range conf.Roles {
+		az.includes[role] = make(map[string]bool)
+		az.expand(role, role, nil)
+	}
+	return az
+}
+
+// expand adds the roles included by role to the included roles of top
+func (az *Authorizer) expand(top, role string, path []string) {
+	for _, p := range path {
+		if p == role {
+			panic("authz: role " + role + " includes itself")
+		}
+	}
+	path = append(path, role)
+	for _, inc := range az.Roles[role] {
+		az.includes[top][inc] = true
+		az.expand(top, inc, path)
+	}
+}
+
+// HasRole reports whether the role is, or includes, the wanted role
+func (az *Authorizer) HasRole(role, want string) bool {
+	return role != "" && (role == want || az.includes[role][want])
+}
+
+// Can reports whether the role has been granted the permission,
+// directly or through a role it includes
+func (az *Authorizer) Can(role, perm string) bool {
+	if role == "" {
+		return false
+	}
+	if grants(az.Permissions[role], perm) {
+		return true
+	}
+	for inc := range az.includes[role] {
+		if grants(az.Permissions[inc], perm) {
+			return true
+		}
+	}
+	return false
+}
+
+func grants(perms []string, perm string) bool {
+	for _, p := range perms {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireLogin returns middleware only letting logged in users through.
+// Other users are redirected to the login URL, with the page they asked
+// for in the return parameter (see ReturnURL), and clients asking for
+// JSON get a 401.
+func (az *Authorizer) RequireLogin() Middleware {
+	return az.require(func(role string) bool { return true }, "")
+}
+
+// RequireRole returns middleware only letting users with one of the
+// roles through (see HasRole.) Users who are not logged in are treated
+// as by RequireLogin, and anyone else gets a 403.
+func (az *Authorizer) RequireRole(roles ...string) Middleware {
+	if len(roles) == 0 {
+		panic("authz: RequireRole requires a role")
+	}
+	allowed := func(role string) bool {
+		for _, want := range roles {
+			if az.HasRole(role, want) {
+				return true
+			}
+		}
+		return false
+	}
+	return az.require(allowed, "requires role "+strings.Join(roles, " or "))
+}
+
+// RequirePermission returns middleware only letting users with all of
+// the permissions through (see Can.) Users who are not logged in are
+// treated as by RequireLogin, and anyone else gets a 403.
+func (az *Authorizer) RequirePermission(perms ...string) Middleware {
+	if len(perms) == 0 {
+		panic("authz: RequirePermission requires a permission")
+	}
+	allowed := func(role string) bool {
+		for _, perm := range perms {
+			if !az.Can(role, perm) {
+				return false
+			}
+		}
+		return true
+	}
+	return az.require(allowed, "requires permission "+strings.Join(perms, " and "))
+}
+
+func (az *Authorizer) require(allowed func(role string) bool, reason string) Middleware {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			username, role, ok := az.identity(r)
+			if !ok {
+				az.login(w, r)
+				return
+			}
+			if !allowed(role) {
+				az.deny(w, r, username, reason)
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// identity returns the logged in user of the request, if there is one
+func (az *Authorizer) identity(r *http.Request) (username, role string, ok bool) {
+	if user := UserFrom(r); user != nil {
+		return user.Username, user.Role, true
+	}
+	sess := SessionFrom(r)
+	if sess == nil && az.Sessions != nil {
+		sess, _ = az.Sessions.Get(r)
+	}
+	if sess == nil {
+		return "", "", false
+	}
+	username, _ = sessionString(sess, SessionUsernameKey)
+	if username == "" {
+		return "", "", false
+	}
+	role, _ = sessionString(sess, SessionRoleKey)
+	return username, role, true
+}
+
+func sessionString(sess *Session, k string) (string, bool) {
+	v, ok := sess.Get(k)
+	if !ok {
+		return "", false
+	}
+	s, ok := v.(string)
+	return s, ok
+}
+
+// login sends a user who is not logged in to the login page
+func (az *Authorizer) login(w http.ResponseWriter, r *http.Request) {
+	if wantsJSON(r) {
+		az.error(w, r, http.StatusUnauthorized)
+		return
+	}
+	u := az.LoginURL
+	if r.Method == http.MethodGet {
+		sep := "?"
+		if strings.Contains(u, "?") {
+			sep = "&"
+		}
+		u += sep + url.QueryEscape(az.ReturnParam) + "=" + url.QueryEscape(r.URL.RequestURI())
+	}
+	http.Redirect(w, r, u, http.StatusSeeOther)
+}
+
+// deny logs the denied request and writes a 403
+func (az *Authorizer) deny(w http.ResponseWriter, r *http.Request, username, reason string) {
+	if az.Muxer != nil && az.Muxer.withLogging {
+		az.Muxer.logger.Warn("authz: denied %s %s to %q: %s\n", r.Method, r.URL.Path, username, reason)
+	}
+	az.error(w, r, http.StatusForbidden)
+}
+
+func (az *Authorizer) error(w http.ResponseWriter, r *http.Request, code int) {
+	if az.Muxer == nil {
+		DefaultErrorRenderer(w, r, code)
+		return
+	}
+	az.Muxer.Error(w, r, code)
+}
+
+// ReturnURL returns the page to return to after logging in, from the
+// return parameter of the request, or fallback if there is none. Only
+// paths on the same site are returned, so the parameter can't be used
+// to send users elsewhere.
+func (az *Authorizer) ReturnURL(r *http.Request, fallback string) string {
+	next := r.FormValue(az.ReturnParam)
+	if !isLocalURL(next) {
+		return fallback
+	}
+	return next
+}
+
+// isLocalURL reports whether u is a path on the same site
+func isLocalURL(u string) bool {
+	if u == "" || u[0] != '/' {
+		return false
+	}
+	// "//host" and "/\host" are treated by browsers as other sites
+	if len(u) > 1 && (u[1] == '/' || u[1] == '\\') {
+		return false
+	}
+	p, err := url.Parse(u)
+	return err == nil && p.Scheme == "" && p.Host == ""
+}
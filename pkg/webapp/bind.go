@@ -0,0 +1,255 @@
+package webapp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxMultipartMemory is the maximum number of bytes of a multipart
+// form that Bind will hold in memory, the rest is stored on disk
+var MaxMultipartMemory int64 = 32 << 20
+
+// MaxBodySize is the maximum number of bytes of a JSON body that Bind
+// will read, the same limit net/http puts on url encoded forms
+var MaxBodySize int64 = 10 << 20
+
+var (
+	ErrBindTarget      = errors.New("bind: destination must be a non-nil pointer to a struct")
+	ErrBindContentType = errors.New("bind: unsupported content type")
+)
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	fileHeaderType = reflect.TypeOf(&multipart.FileHeader{})
+)
+
+// Bind decodes the request into the struct pointed to by dst and then
+// validates it (see Validate.) Query parameters are decoded first, and
+// then the body depending on its content type, which may be JSON, a url
+// encoded form or a multipart form. Form and query values are matched to
+// fields using the `form` struct tag, or the lower case field name if
+// there is no tag, and fields tagged `form:"-"` are skipped. Multipart
+// file uploads may be bound to *multipart.FileHeader fields.
+//
+// If decoding fails an error is returned, if validation fails the error
+// returned is ValidationErrors, which holds a message for every field
+// that failed, including any values that could not be converted.
+//
+//	type Signup struct {
+//		Name  string `form:"name" validate:"required,max=50"`
+//		Email string `form:"email" validate:"required,email"`
+//		Age   int    `form:"age" validate:"min=18"`
+//	}
+func Bind(r *http.Request, dst interface{}) error {
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return ErrBindTarget
+	}
+	var errs ValidationErrors
+	errs = append(errs, bindValues(rv.Elem(), r.URL.Query(), nil)...)
+	if r.Body != nil && r.Body != http.NoBody && r.Method != http.MethodGet && r.Method != http.MethodHead {
+		ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		switch {
+		case ct == MimeJSON || strings.HasSuffix(ct, "+json"):
+			body := http.MaxBytesReader(nil, r.Body, MaxBodySize)
+			err := json.NewDecoder(body).Decode(dst)
+			if err != nil {
+				return fmt.Errorf("bind: decoding json: %w", err)
+			}
+		case ct == "application/x-www-form-urlencoded":
+			err := r.ParseForm()
+			if err != nil {
+				return fmt.Errorf("bind: parsing form: %w", err)
+			}
+			errs = append(errs, bindValues(rv.Elem(), r.PostForm, nil)...)
+		case ct == "multipart/form-data":
+			err := r.ParseMultipartForm(MaxMultipartMemory)
+			if err != nil {
+				return fmt.Errorf("bind: parsing multipart form: %w", err)
+			}
+			errs = append(errs, bindValues(rv.Elem(), r.MultipartForm.Value, r.MultipartForm.File)...)
+		case ct == "":
+			// nothing to decode
+		default:
+			return ErrBindContentType
+		}
+	}
+	verrs, err := validateStruct(rv.Elem(), "")
+	if err != nil {
+		return err
+	}
+	errs = append(errs, verrs...)
+	if len(errs) > 0 {
+		return errs.dedupe()
+	}
+	return nil
+}
+
+// formName returns the form key for a struct field, and false if the
+// field should be skipped
+func formName(f reflect.StructField) (string, bool) {
+	if f.PkgPath != "" && !f.Anonymous {
+		return "", false // unexported
+	}
+	tag := f.Tag.Get("form")
+	if tag == "-" {
+		return "", false
+	}
+	if tag == "" {
+		return strings.ToLower(f.Name), true
+	}
+	return tag, true
+}
+
+// bindValues sets the fields of the struct v from the supplied values
+func bindValues(v reflect.Value, vals url.Values, files map[string][]*multipart.FileHeader) ValidationErrors {
+	var errs ValidationErrors
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		fv := v.Field(i)
+		if f.Anonymous && fv.Kind() == reflect.Struct {
+			errs = append(errs, bindValues(fv, vals, files)...)
+			continue
+		}
+		name, ok := formName(f)
+		if !ok || !fv.CanSet() {
+			continue
+		}
+		if f.Type == fileHeaderType {
+			if fhs := files[name]; len(fhs) > 0 {
+				fv.Set(reflect.ValueOf(fhs[0]))
+			}
+			continue
+		}
+		if f.Type.Kind() == reflect.Slice && f.Type.Elem() == fileHeaderType {
+			if fhs := files[name]; len(fhs) > 0 {
+				fv.Set(reflect.ValueOf(fhs))
+			}
+			continue
+		}
+		ss, ok := vals[name]
+		if !ok {
+			continue
+		}
+		if err := setField(fv, ss); err != nil {
+			errs = append(errs, FieldError{
+				Field:   name,
+				Rule:    "type",
+				Message: err.Error(),
+			})
+		}
+	}
+	return errs
+}
+
+// setField converts and sets the value(s) of a single field
+func setField(fv reflect.Value, ss []string) error {
+	if fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() != reflect.Uint8 {
+		sl := reflect.MakeSlice(fv.Type(), len(ss), len(ss))
+		for i, s := range ss {
+			if err := setValue(sl.Index(i), s); err != nil {
+				return err
+			}
+		}
+		fv.Set(sl)
+		return nil
+	}
+	if len(ss) == 0 {
+		return nil
+	}
+	return setValue(fv, ss[0])
+}
+
+// setValue converts and sets a single value
+func setValue(v reflect.Value, s string) error {
+	if v.Kind() == reflect.Ptr {
+		if s == "" {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		pv := reflect.New(v.Type().Elem())
+		if err := setValue(pv.Elem(), s); err != nil {
+			return err
+		}
+		v.Set(pv)
+		return nil
+	}
+	if v.Type() == timeType {
+		if s == "" {
+			v.Set(reflect.Zero(timeType))
+			return nil
+		}
+		for _, layout := range []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02"} {
+			if t, err := time.Parse(layout, s); err == nil {
+				v.Set(reflect.ValueOf(t))
+				return nil
+			}
+		}
+		return errors.New("must be a valid date")
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		if s == "" {
+			v.SetBool(false)
+			return nil
+		}
+		if s == "on" {
+			// browsers send "on" for checked checkboxes without a value
+			v.SetBool(true)
+			return nil
+		}
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return errors.New("must be true or false")
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if s == "" {
+			v.SetInt(0)
+			return nil
+		}
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return errors.New("must be a whole number")
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if s == "" {
+			v.SetUint(0)
+			return nil
+		}
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return errors.New("must be a positive whole number")
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		if s == "" {
+			v.SetFloat(0)
+			return nil
+		}
+		n, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return errors.New("must be a number")
+		}
+		v.SetFloat(n)
+	case reflect.Slice:
+		// []byte
+		v.SetBytes([]byte(s))
+	default:
+		return fmt.Errorf("unsupported field type %s", v.Type())
+	}
+	return nil
+}
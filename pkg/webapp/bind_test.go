@@ -0,0 +1,147 @@
+package webapp
+
+import (
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testAddress struct {
+	Street string `json:"street" validate:"required"`
+	Zip    string `json:"zip" validate:"len=5"`
+}
+
+type testAudit struct {
+	Note string `form:"note" validate:"max=5"`
+}
+
+type testSignup struct {
+	testAudit
+	Name    string       `form:"name" json:"name" validate:"required,max=10"`
+	Email   string       `form:"email" json:"email" validate:"email"`
+	Age     int          `form:"age" json:"age" validate:"min=18"`
+	Tags    []string     `form:"tag" json:"tags" validate:"max=2"`
+	Address testAddress  `form:"-" json:"address"`
+	Billing *testAddress `form:"-" json:"billing"`
+}
+
+func TestBind(t *testing.T) {
+	tests := []struct {
+		name  string
+		ctype string
+		body  string
+		query string
+		want  ValidationErrors
+	}{
+		{"valid form", "application/x-www-form-urlencoded", "name=al&age=20&tag=a&tag=b", "", nil},
+		{"query and form", "application/x-www-form-urlencoded", "age=20", "name=al", nil},
+		// conversion errors come first, and hide later ones for a field
+		{"form errors", "application/x-www-form-urlencoded", "name=&email=nope&age=x&tag=a&tag=b&tag=c&note=toolong", "", ValidationErrors{
+			{"age", "type", "must be a whole number"},
+			{"note", "max", "must have at most 5 characters"},
+			{"name", "required", "is required"},
+			{"email", "email", "must be a valid email address"},
+			{"tag", "max", "must have at most 2 items"},
+		}},
+		{"valid json", MimeJSON, `{"name":"al","age":20,"address":{"street":"Main"}}`, "", nil},
+		{"nested json", MimeJSON, `{"name":"al","age":17,"billing":{"zip":"123"}}`, "", ValidationErrors{
+			{"age", "min", "must be at least 18"},
+			{"address.street", "required", "is required"},
+			{"billing.street", "required", "is required"},
+			{"billing.zip", "len", "must have exactly 5 characters"},
+		}},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/?"+tt.query, strings.NewReader(tt.body))
+		r.Header.Set("Content-Type", tt.ctype)
+		var dst testSignup
+		if tt.ctype == "application/x-www-form-urlencoded" {
+			dst.Address.Street = "Main"
+		}
+		err := Bind(r, &dst)
+		var got ValidationErrors
+		if err != nil && !errors.As(err, &got) {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: errors\n%v\nwant\n%v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBindErrors(t *testing.T) {
+	defer func(n int64) { MaxBodySize = n }(MaxBodySize)
+	MaxBodySize = 16
+	tests := []struct {
+		name  string
+		ctype string
+		body  string
+		dst   interface{}
+		want  error
+	}{
+		{"not a pointer", MimeJSON, `{}`, testSignup{}, ErrBindTarget},
+		{"unsupported content type", "text/plain", "hi", new(testSignup), ErrBindContentType},
+		{"broken json", MimeJSON, `{"name":`, new(testSignup), nil},
+		{"json too large", MimeJSON, `{"name":"` + strings.Repeat("a", 20) + `"}`, new(testSignup), nil},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+		r.Header.Set("Content-Type", tt.ctype)
+		err := Bind(r, tt.dst)
+		var verrs ValidationErrors
+		if err == nil || errors.As(err, &verrs) || (tt.want != nil && !errors.Is(err, tt.want)) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestValidateRules(t *testing.T) {
+	type unknown struct {
+		Name string `validate:"required,nonsense"`
+	}
+	type badMin struct {
+		Name string `validate:"min=x"`
+	}
+	type badRegex struct {
+		Name string `validate:"regex=("`
+	}
+	type badKind struct {
+		On bool `validate:"max=1"`
+	}
+	type nested struct {
+		Inner unknown
+	}
+	tests := []struct {
+		name string
+		v    interface{}
+		want string
+	}{
+		{"unknown rule", unknown{}, `unknown rule "nonsense"`},
+		{"empty value, unknown rule", unknown{Name: "x"}, `unknown rule "nonsense"`},
+		{"invalid min", badMin{}, `invalid min value "x"`},
+		{"invalid regex", badRegex{}, "invalid regex"},
+		{"unsupported kind", badKind{}, "max is not supported for bool"},
+		{"nested", nested{}, `unknown rule "nonsense"`},
+	}
+	for _, tt := range tests {
+		err := Validate(tt.v)
+		var verrs ValidationErrors
+		if err == nil || errors.As(err, &verrs) || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+	type valid struct {
+		Code string `json:"code" validate:"required,regex=^[a-z]{2,3}$"`
+	}
+	if err := Validate(&valid{Code: "abc"}); err != nil {
+		t.Errorf("valid value: %v", err)
+	}
+	err := Validate(valid{Code: "a,b"})
+	var verrs ValidationErrors
+	if !errors.As(err, &verrs) || verrs.Get("code") != "is not in the correct format" {
+		t.Errorf("regex: err = %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+package webapp
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/http"
+)
+
+const (
+	// CSRFFieldName is the name of the form field holding the CSRF token
+	CSRFFieldName = "csrf_token"
+
+	// CSRFHeaderName is the request header that may hold the CSRF
+	// token instead, for requests made from scripts
+	CSRFHeaderName = "X-CSRF-Token"
+
+	// csrfSessionKey is the session key the CSRF secret is stored under
+	csrfSessionKey = "_csrf"
+
+	csrfSecretLen = 32
+)
+
+// csrfKey is the context key for the CSRF secret of a request
+type csrfKey struct{}
+
+// CSRF returns middleware protecting against cross site request forgery.
+// A secret is stored in the session of every client (starting a session
+// if there is not one already) and any request with an unsafe method
+// (anything but GET, HEAD, OPTIONS and TRACE) must carry a token made
+// from that secret, either in the CSRFFieldName form field or in the
+// CSRFHeaderName header. Tokens are masked with a random pad each time
+// one is made, so they differ on every page and can't be recovered by
+// compression attacks such as BREACH.
+//
+// When the routes also use the session middleware (see
+// SessionStore.Middleware) the session is taken from the request, and
+// the CSRF middleware must come after it.
+//
+// Use CSRFToken or CSRFField to get a token for a request, or the
+// "csrfField" template function. Rejected requests are logged using the
+// muxer's logger and get a 403 via Muxer.Error. The muxer may be nil.
+func CSRF(sm SessionManager, mux *Muxer) Middleware {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			// use the session from the session middleware if there is
+			// one, it saves the session itself once it has been changed
+			sess, managed := SessionFrom(r), true
+			if sess == nil {
+				var ok bool
+				sess, ok = sm.Get(r)
+				if !ok {
+					sess = sm.New()
+				}
+				managed = false
+			}
+			secret := csrfSecret(sess)
+			if secret == nil {
+				secret = make([]byte, csrfSecretLen)
+				if _, err := rand.Read(secret); err != nil {
+					csrfReject(w, r, mux, http.StatusInternalServerError, fmt.Sprintf("generating secret: %s", err))
+					return
+				}
+				sess.Set(csrfSessionKey, base64.RawURLEncoding.EncodeToString(secret))
+				if !managed {
+					sm.Save(w, r, sess)
+				}
+			}
+			// the response depends on the session cookie
+			w.Header().Add("Vary", "Cookie")
+			r = r.WithContext(context.WithValue(r.Context(), csrfKey{}, secret))
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+				next.ServeHTTP(w, r)
+				return
+			}
+			token := r.Header.Get(CSRFHeaderName)
+			if token == "" {
+				token = r.PostFormValue(CSRFFieldName)
+			}
+			if token == "" {
+				csrfReject(w, r, mux, http.StatusForbidden, "missing token")
+				return
+			}
+			if !csrfTokenValid(token, secret) {
+				csrfReject(w, r, mux, http.StatusForbidden, "invalid token")
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// CSRFToken returns a new masked CSRF token for the request, or an
+// empty string if the request has not been through the CSRF middleware
+func CSRFToken(r *http.Request) string {
+	secret, ok := r.Context().Value(csrfKey{}).([]byte)
+	if !ok {
+		return ""
+	}
+	return csrfMask(secret)
+}
+
+// CSRFField returns a hidden form input holding a CSRF token for the
+// request, it is also available to templates as "csrfField"
+func CSRFField(r *http.Request) template.HTML {
+	token := CSRFToken(r)
+	if token == "" {
+		return ""
+	}
+	return template.HTML(`<input type="hidden" name="` + CSRFFieldName + `" value="` + token + `">`)
+}
+
+// csrfSecret returns the CSRF secret stored in the session, if any
+func csrfSecret(sess *Session) []byte {
+	v, ok := sess.Get(csrfSessionKey)
+	if !ok {
+		return nil
+	}
+	s, _ := v.(string)
+	secret, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(secret) != csrfSecretLen {
+		return nil
+	}
+	return secret
+}
+
+// csrfMask returns the secret xor'd with a random pad, with the pad
+// prepended, so that a different token is produced every time
+func csrfMask(secret []byte) string {
+	b := make([]byte, 2*len(secret))
+	pad := b[:len(secret)]
+	if _, err := rand.Read(pad); err != nil {
+		return ""
+	}
+	for i := range secret {
+		b[len(secret)+i] = pad[i] ^ secret[i]
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// csrfTokenValid reports whether the masked token was made from the secret
+func csrfTokenValid(token string, secret []byte) bool {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(b) != 2*len(secret) {
+		return false
+	}
+	pad, masked := b[:len(secret)], b[len(secret):]
+	for i := range masked {
+		masked[i] ^= pad[i]
+	}
+	return subtle.ConstantTimeCompare(masked, secret) == 1
+}
+
+// csrfReject logs the rejected request and writes the error response
+func csrfReject(w http.ResponseWriter, r *http.Request, mux *Muxer, code int, reason string) {
+	if mux == nil {
+		DefaultErrorRenderer(w, r, code)
+		return
+	}
+	if mux.withLogging {
+		mux.logger.Warn("csrf: rejected %s %s from %s: %s\n", r.Method, r.URL.Path, r.RemoteAddr, reason)
+	}
+	mux.Error(w, r, code)
+}
@@ -0,0 +1,96 @@
+package webapp
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCSRFMask(t *testing.T) {
+	secret := []byte(strings.Repeat("s", csrfSecretLen))
+	other := []byte(strings.Repeat("o", csrfSecretLen))
+	token := csrfMask(secret)
+	if token == csrfMask(secret) {
+		t.Error("tokens are not masked differently each time")
+	}
+	tests := []struct {
+		name   string
+		token  string
+		secret []byte
+		want   bool
+	}{
+		{"round trip", token, secret, true},
+		{"other secret", token, other, false},
+		{"tampered", tamper(token), secret, false},
+		{"unmasked secret", base64.RawURLEncoding.EncodeToString(secret), secret, false},
+		{"too short", token[:20], secret, false},
+		{"not base64", "!!!", secret, false},
+		{"empty", "", secret, false},
+	}
+	for _, tt := range tests {
+		if got := csrfTokenValid(tt.token, tt.secret); got != tt.want {
+			t.Errorf("%s: valid = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCSRFMiddleware(t *testing.T) {
+	ss := NewSessionStore(nil)
+	h := NewChain(ss.Middleware(), CSRF(ss, nil)).ThenFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(CSRFToken(r)))
+	})
+	// a GET starts a session and hands out a token
+	get := func() (*http.Cookie, string) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if w.Code != http.StatusOK || len(w.Result().Cookies()) == 0 {
+			t.Fatalf("GET: %d, no session cookie", w.Code)
+		}
+		return w.Result().Cookies()[0], w.Body.String()
+	}
+	cookie, token := get()
+	_, otherToken := get()
+
+	tests := []struct {
+		name   string
+		form   string
+		header string
+		cookie bool
+		want   int
+	}{
+		{"form field", token, "", true, http.StatusOK},
+		{"header", "", token, true, http.StatusOK},
+		{"missing token", "", "", true, http.StatusForbidden},
+		{"tampered token", tamper(token), "", true, http.StatusForbidden},
+		{"token of another session", otherToken, "", true, http.StatusForbidden},
+		{"no session", token, "", false, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		form := url.Values{}
+		if tt.form != "" {
+			form.Set(CSRFFieldName, tt.form)
+		}
+		r := httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if tt.header != "" {
+			r.Header.Set(CSRFHeaderName, tt.header)
+		}
+		if tt.cookie {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
+
+func TestCSRFField(t *testing.T) {
+	if CSRFField(httptest.NewRequest("GET", "/", nil)) != "" {
+		t.Error("field made outside the middleware")
+	}
+}
@@ -7,18 +7,18 @@ import (
 	"net/http"
 )
 
-func handleIndex(t *webapp.TemplateCache) http.Handler {
+func handleIndex(rd *webapp.Renderer) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		t.ExecuteTemplate(w, "index.html", map[string]interface{}{})
+		rd.HTML(w, http.StatusOK, "index.html", map[string]interface{}{"Request": r})
 	}
 	return http.HandlerFunc(fn)
 }
 
-func handleLogin(t *webapp.TemplateCache, ss *webapp.SessionStore, ba *webapp.SystemSessionUser) http.Handler {
+func handleLogin(t *webapp.TemplateCache, ba *webapp.SystemSessionUser, az *webapp.Authorizer) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
-			t.ExecuteTemplate(w, "login.html", map[string]interface{}{})
+			t.ExecuteTemplate(w, "login.html", map[string]interface{}{"Request": r})
 			return
 		case http.MethodPost:
 			err := r.ParseForm()
@@ -29,45 +29,50 @@ func handleLogin(t *webapp.TemplateCache, ss *webapp.SessionStore, ba *webapp.Sy
 			pass := r.Form.Get("password")
 			su, authd := ba.Authenticate(user, pass)
 			if !authd {
-				t.ExecuteTemplate(w, "login.html", map[string]interface{}{})
+				webapp.SessionFrom(r).AddFlash(webapp.FlashError, "Invalid username or password. Please try again.")
+				http.Redirect(w, r, mux.MustURL("login"), http.StatusSeeOther)
 				return
 			}
-			sess := ss.New()
-			sess.Set("user", su)
-			ss.Save(w, r, sess)
-			http.Redirect(w, r, "/secure/home", http.StatusTemporaryRedirect)
+			// new id on login, so a fixed session id is never logged in
+			sess := webapp.SessionFrom(r)
+			sess.Regenerate()
+			sess.Set(webapp.SessionUsernameKey, su.Username)
+			sess.Set(webapp.SessionRoleKey, su.Role)
+			sess.AddFlash(webapp.FlashSuccess, "Welcome back!")
+			// back to the page that required the login, if there was one
+			http.Redirect(w, r, az.ReturnURL(r, mux.MustURL("secure-home")), http.StatusSeeOther)
 			return
 		}
 	}
 	return http.HandlerFunc(fn)
 }
 
-func handleSecureHome(ss *webapp.SessionStore) http.Handler {
+func handleSecureHome() http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		sess, ok := ss.Get(r)
-		if !ok {
-			http.Redirect(w, r, "/error/401", http.StatusTemporaryRedirect)
-			return
+		// the login is checked by the RequireLogin middleware
+		sess := webapp.SessionFrom(r)
+		usr, _ := sess.Get(webapp.SessionUsernameKey)
+		role, _ := sess.Get(webapp.SessionRoleKey)
+		fmt.Fprintf(w, "this is my secure home (session.id=%s, user=%s, role=%s)\n", sess.ID(), usr, role)
+		for _, f := range sess.Flashes() {
+			fmt.Fprintf(w, "%s: %s\n", f.Level, f.Message)
 		}
-		usr, _ := sess.Get("user")
-		ss.Save(w, r, sess)
-		fmt.Fprintf(w, "this is my secure home (session.id=%s, role=%s)\n", sess.ID(), usr)
 		return
 	}
 	return http.HandlerFunc(fn)
 }
 
-func handleLogout(ss *webapp.SessionStore) http.Handler {
+func handleLogout() http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		ss.Save(w, r, nil)
-		http.Redirect(w, r, "/login", http.StatusTemporaryRedirect)
+		webapp.SessionFrom(r).Destroy()
+		http.Redirect(w, r, mux.MustURL("login"), http.StatusTemporaryRedirect)
 	}
 	return http.HandlerFunc(fn)
 }
 
-func handleTemplates(t *webapp.TemplateCache) http.Handler {
+func handleTemplates(t *webapp.TemplateCache, rd *webapp.Renderer) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprintf(w, "%s", t.DefinedTemplates())
+		rd.Text(w, http.StatusOK, "%s", t.DefinedTemplates())
 		return
 	}
 	return http.HandlerFunc(fn)
@@ -10,9 +10,11 @@ import (
 )
 
 var (
-	tc *webapp.TemplateCache
-	ss *webapp.SessionStore
-	ba *webapp.SystemSessionUser
+	tc  *webapp.TemplateCache
+	ss  *webapp.SessionStore
+	ba  *webapp.SystemSessionUser
+	mux *webapp.Muxer
+	rd  *webapp.Renderer
 )
 
 func init() {
@@ -25,21 +27,44 @@ func init() {
 
 	// init session store
 	ss = webapp.NewSessionStore(&webapp.SessionConfig{
-		SessionID: "sess-id",
-		Timeout:   time.Duration(30) * time.Second,
+		SessionID:   "sess-id",
+		IdleTimeout: time.Duration(30) * time.Second,
 	})
 
 	// init basic auth user
 	ba = webapp.NewSystemSessionUser()
-	ba.Register("jdoe@example.com", "awesome007", "user")
+	err := ba.Register("jdoe@example.com", "awesome007", "user")
+	if err != nil {
+		log.Panic(err)
+	}
+
+	// init muxer, and let the templates build urls from it
+	mux = webapp.NewMuxer(&webapp.MuxerConfig{
+		StaticHandler: webapp.DefaultMuxerStaticHandler("pkg/webapp/example/main/web/static/"),
+		ErrHandler:    webapp.DefaultMuxerErrorHandler(),
+		MetricsOn:     true,
+		Logging:       webapp.LevelInfo,
+	})
+	tc.Funcs(mux.URLFuncMap())
+
+	// init renderer, reporting any errors to the muxer
+	rd = webapp.NewRenderer(tc, mux.Logger(), mux.Error)
 }
 
 func initWebApp() {
 
 	// basic config
 	config := &webapp.WebAppConfig{
-		Templates: nil,
-		Sessions:  nil,
+		Templates: &webapp.TemplateConfig{
+			BasePattern:   "pkg/webapp/example/main/web/templates/*.html",
+			ExtraPatterns: []string{"pkg/webapp/example/main/web/templates/stubs/*.html"},
+		},
+		Sessions: &webapp.SessionConfig{
+			SessionID: "sess-id",
+		},
+		Auth: &webapp.AuthConfig{
+			SuccessURL: "/secure/home",
+		},
 		Muxer: &webapp.MuxerConfig{
 			StaticHandler: webapp.DefaultMuxerStaticHandler("pkg/webapp/example/main/web/static/"),
 			ErrHandler:    webapp.DefaultMuxerErrorHandler(),
@@ -59,6 +84,12 @@ func initWebApp() {
 		return
 	}))
 
+	// add the login, logout and register routes, and a page
+	// for logged in users
+	app.MountAuth()
+	secure := app.SessionChain().Append(app.Authz.RequireLogin())
+	app.Muxer.Get("/secure/home", secure.Then(handleSecureHome()))
+
 	// serve it up
 	log.Panic(http.ListenAndServe(":8181", app))
 }
@@ -66,16 +97,21 @@ func initWebApp() {
 func main() {
 
 	// server
-	mux := http.NewServeMux()
-	mux.Handle("/error/", webapp.DefaultMuxerErrorHandler())
-	mux.Handle("/index", handleIndex(tc))
-	mux.Handle("/login", handleLogin(tc, ss, ba))
-	mux.Handle("/logout", handleLogout(ss))
-	mux.Handle("/sessions", handleSessions(ss))
-	mux.Handle("/secure/home", handleSecureHome(ss))
-	mux.Handle("/templates", handleTemplates(tc))
-	mux.Handle("/bootstrap", handleBootstrapExample())
-	mux.Handle("/static/", webapp.StaticHandler("/static", "pkg/webapp/example/main/web/static/"))
+	sessions := ss.Middleware()
+	mux.Get("/index", sessions(handleIndex(rd))).Name("index")
+	csrf := webapp.CSRF(ss, mux)
+	az := webapp.NewAuthorizer(&webapp.AuthzConfig{
+		LoginURL: "/login",
+		Muxer:    mux,
+	})
+	mux.Get("/login", sessions(csrf(handleLogin(tc, ba, az)))).Name("login")
+	mux.Post("/login", sessions(csrf(handleLogin(tc, ba, az))))
+	mux.Get("/logout", sessions(handleLogout())).Name("logout")
+	mux.Get("/sessions", handleSessions(ss)).Name("sessions")
+	secure := webapp.NewChain(sessions, az.RequireLogin())
+	mux.Get("/secure/home", secure.Then(handleSecureHome())).Name("secure-home")
+	mux.Get("/templates", handleTemplates(tc, rd)).Name("templates")
+	mux.Get("/bootstrap", handleBootstrapExample()).Name("bootstrap")
 	log.Fatal(http.ListenAndServe(":8080", mux))
 
 }
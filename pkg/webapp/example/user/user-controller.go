@@ -3,10 +3,22 @@ package user
 import (
 	"fmt"
 	"github.com/cagnosolutions/go-web-ddd/pkg/webapp"
+	"github.com/cagnosolutions/go-web-ddd/pkg/webapp/forms"
 	"html/template"
 	"net/http"
 )
 
+// userForm is the form used to add a new user
+var userForm = func() *forms.Form {
+	form, err := forms.MakeFormFromStruct(&User{})
+	if err != nil {
+		panic(err)
+	}
+	form.Name = "Add User"
+	form.Action = "/user"
+	return form
+}()
+
 // UserController implements the Controller interface
 // for use with the standard library http package
 type UserController struct {
@@ -40,32 +52,34 @@ func (con *UserController) HandleBase() http.Handler {
 }
 
 func (con *UserController) handleBaseRequest(w http.ResponseWriter, r *http.Request) {
-	// load the user form page
-	if r.Method == http.MethodGet {
-		err := con.tmpls.ExecuteTemplate(w, "user.html", nil)
+	switch r.Method {
+	case http.MethodGet:
+		// load the user form page, with any messages above the form
+		form := userForm.WithCSRF(webapp.CSRFToken(r))
+		_ = con.tmpls.ExecuteTemplate(w, "user.html", webapp.RenderFlashes(r)+form.HTML())
+	case http.MethodPost:
+		// check the posted form, rendering it again with any errors
+		vals, form, err := userForm.Process(r)
 		if err != nil {
-			code := http.StatusBadRequest
-			http.Error(w, http.StatusText(code), code)
-		}
-		return
-	}
-	// otherwise, user has posted something
-	if r.Method == http.MethodPost {
-		err := r.ParseForm()
-		if err != nil {
-			code := http.StatusBadRequest
-			http.Error(w, http.StatusText(code), code)
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			form = form.WithCSRF(webapp.CSRFToken(r))
+			_ = con.tmpls.ExecuteTemplate(w, "user.html", form.HTML())
 			return
 		}
 		// save the user that was posted
-		id, err := con.userService.AddNewUser(r)
+		id, err := con.userService.AddNewUser(vals)
 		if err != nil {
-			code := http.StatusBadRequest
+			code := http.StatusInternalServerError
 			http.Error(w, http.StatusText(code), code)
 			return
 		}
+		// show a message after redirecting, if there is a session
+		if sess := webapp.SessionFrom(r); sess != nil {
+			sess.AddFlash(webapp.FlashSuccess, fmt.Sprintf("User saved (id=%d).", id))
+			http.Redirect(w, r, "/user", http.StatusSeeOther)
+			return
+		}
 		fmt.Fprintf(w, "successfully added user, id=%d\n", id)
-		return
 	}
 }
 
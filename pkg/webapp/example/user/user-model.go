@@ -1,13 +1,15 @@
 package user
 
+import "github.com/cagnosolutions/go-web-ddd/pkg/webapp"
+
 // User is a user model
 type User struct {
-	ID           int
-	FirstName    string
-	LastName     string
-	EmailAddress string
-	Password     string
-	IsActive     bool
+	ID           int    `form:"-"`
+	FirstName    string `form:"first" validate:"required,max=50" html:"maxlen=50"`
+	LastName     string `form:"last" validate:"required,max=50" html:"maxlen=50"`
+	EmailAddress string `form:"email" validate:"required,email" html:"label='Email'"`
+	Password     string `form:"password" validate:"required,min=8" html:"minlen=8"`
+	IsActive     bool   `form:"-"`
 }
 
 func NewUser(fname, lname, email string) *User {
@@ -19,9 +21,20 @@ func NewUser(fname, lname, email string) *User {
 	}
 }
 
-func (u *User) UpdatePassword(pass string) {
-	//u.Password = fmt.Sprintf("%s", sha256.Sum256([]byte(pass)))
-	u.Password = pass
+// UpdatePassword sets the password of the user, which is stored hashed
+func (u *User) UpdatePassword(pass string) error {
+	hash, err := webapp.HashPassword(pass)
+	if err != nil {
+		return err
+	}
+	u.Password = hash
+	return nil
+}
+
+// CheckPassword reports whether pass is the password of the user,
+// and whether the stored hash should be replaced by a new one
+func (u *User) CheckPassword(pass string) (ok, rehash bool) {
+	return webapp.CheckPassword(pass, u.Password)
 }
 
 // GetID helps satisfy the Entity interface
@@ -2,7 +2,7 @@ package user
 
 import (
 	"github.com/cagnosolutions/go-web-ddd/pkg/webapp"
-	"net/http"
+	"github.com/cagnosolutions/go-web-ddd/pkg/webapp/forms"
 )
 
 // UserService implements the Servicer interface
@@ -19,14 +19,13 @@ func (service *UserService) AddRepository(repo webapp.Repository) {
 	service.userRepo = repo.(*UserRepository)
 }
 
-func (service *UserService) AddNewUser(r *http.Request) (int, error) {
-	// get the posted user
-	user := &User{
-		FirstName:    r.FormValue("first"),
-		LastName:     r.FormValue("last"),
-		EmailAddress: r.FormValue("email"),
+// AddNewUser saves a new user from the checked values of the user form
+func (service *UserService) AddNewUser(vals forms.Values) (int, error) {
+	user := NewUser(vals.String("first"), vals.String("last"), vals.String("email"))
+	err := user.UpdatePassword(vals.String("password"))
+	if err != nil {
+		return -1, err
 	}
-	user.UpdatePassword(r.FormValue("password"))
 	// save the new user to the database
 	return service.userRepo.AddUser(user)
 }
@@ -38,9 +37,18 @@ func (service *UserService) GetUser(un, pw string) *User {
 		return nil
 	}
 	for i := range users {
-		if users[i].EmailAddress == un && users[i].Password == pw {
-			return users[i]
+		if users[i].EmailAddress != un {
+			continue
 		}
+		ok, rehash := users[i].CheckPassword(pw)
+		if !ok || !users[i].IsActive {
+			return nil
+		}
+		// upgrade the stored hash if the hashing parameters have changed
+		if rehash && users[i].UpdatePassword(pw) == nil {
+			_ = service.userRepo.SetUser(users[i])
+		}
+		return users[i]
 	}
 	return nil
 }
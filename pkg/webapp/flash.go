@@ -0,0 +1,134 @@
+package webapp
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"net/http"
+)
+
+// FlashLevel is the severity of a flash message
+type FlashLevel int
+
+const (
+	FlashInfo FlashLevel = iota
+	FlashSuccess
+	FlashWarning
+	FlashError
+)
+
+var flashLevelNames = [...]string{
+	FlashInfo:    "info",
+	FlashSuccess: "success",
+	FlashWarning: "warning",
+	FlashError:   "error",
+}
+
+// flashAlertClasses are the bootstrap alert classes for each level
+var flashAlertClasses = [...]string{
+	FlashInfo:    "alert-info",
+	FlashSuccess: "alert-success",
+	FlashWarning: "alert-warning",
+	FlashError:   "alert-danger",
+}
+
+func (l FlashLevel) String() string {
+	if l < 0 || int(l) >= len(flashLevelNames) {
+		return flashLevelNames[FlashInfo]
+	}
+	return flashLevelNames[l]
+}
+
+// AlertClass returns the bootstrap alert class for the level
+func (l FlashLevel) AlertClass() string {
+	if l < 0 || int(l) >= len(flashAlertClasses) {
+		return flashAlertClasses[FlashInfo]
+	}
+	return flashAlertClasses[l]
+}
+
+// Flash is a message kept in the session until it is next shown,
+// so that it can be shown after a redirect
+type Flash struct {
+	Level   FlashLevel `json:"level"`
+	Message string     `json:"message"`
+}
+
+// flashSessionKey is the session key flash messages are stored under
+const flashSessionKey = "_flash"
+
+// AddFlash adds a flash message to the session. The session has to be
+// saved for it to be shown on a later request, which the session
+// middleware does.
+func (s *Session) AddFlash(level FlashLevel, msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	flashes := s.flashes()
+	flashes = append(flashes, Flash{Level: level, Message: msg})
+	// kept as JSON text, so any session serializer can store them
+	b, err := json.Marshal(flashes)
+	if err != nil {
+		return
+	}
+	s.data[flashSessionKey] = string(b)
+	s.modified = true
+}
+
+// Flashes returns the flash messages of the session in the order they
+// were added, and removes them from the session
+func (s *Session) Flashes() []Flash {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	flashes := s.flashes()
+	if _, ok := s.data[flashSessionKey]; ok {
+		delete(s.data, flashSessionKey)
+		s.modified = true
+	}
+	return flashes
+}
+
+// flashes decodes the flash messages, the session must be locked
+func (s *Session) flashes() []Flash {
+	v, _ := s.data[flashSessionKey].(string)
+	if v == "" {
+		return nil
+	}
+	var flashes []Flash
+	if err := json.Unmarshal([]byte(v), &flashes); err != nil {
+		return nil
+	}
+	return flashes
+}
+
+var flashTemplate = template.Must(template.New("flashes").Parse(
+	`{{ range . }}<div class="alert {{ .Level.AlertClass }} alert-dismissible fade show" role="alert">` +
+		`{{ .Message }}` +
+		`<button type="button" class="btn-close" data-bs-dismiss="alert" aria-label="Close"></button>` +
+		`</div>{{ end }}`))
+
+// FlashAlerts renders flash messages as dismissible bootstrap alerts
+func FlashAlerts(flashes []Flash) template.HTML {
+	if len(flashes) == 0 {
+		return ""
+	}
+	var buf bytes.Buffer
+	if err := flashTemplate.Execute(&buf, flashes); err != nil {
+		return ""
+	}
+	return template.HTML(buf.String())
+}
+
+// RenderFlashes takes the flash messages from the session of a request
+// that has been through the session middleware, and renders them using
+// FlashAlerts. It is also available to templates as "flashes", eg.
+// {{ flashes .Request }}
+func RenderFlashes(r *http.Request) template.HTML {
+	if r == nil {
+		return ""
+	}
+	sess := SessionFrom(r)
+	if sess == nil {
+		return ""
+	}
+	return FlashAlerts(sess.Flashes())
+}
@@ -0,0 +1,487 @@
+package forms
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"reflect"
+	"strings"
+)
+
+type FieldType int
+
+const (
+	TypeHidden FieldType = iota
+	TypeText
+	TypeEmail
+	TypeInteger
+	TypeNumber
+	TypePassword
+	TypeTel
+	TypeURL
+	TypeSearch
+	TypeDate
+	TypeDatetimeLocal
+	TypeMonth
+	TypeWeek
+	TypeTime
+	TypeColor
+	TypeRange
+	TypeCheckbox
+	TypeRadio
+	TypeFile
+	TypeImage
+	TypeSelect
+	TypeSubmit
+	TypeReset
+	TypeButton
+)
+
+var fieldTypeNames = [...]string{
+	TypeHidden:        "hidden",
+	TypeText:          "text",
+	TypeEmail:         "email",
+	TypeInteger:       "number",
+	TypeNumber:        "number",
+	TypePassword:      "password",
+	TypeTel:           "tel",
+	TypeURL:           "url",
+	TypeSearch:        "search",
+	TypeDate:          "date",
+	TypeDatetimeLocal: "datetime-local",
+	TypeMonth:         "month",
+	TypeWeek:          "week",
+	TypeTime:          "time",
+	TypeColor:         "color",
+	TypeRange:         "range",
+	TypeCheckbox:      "checkbox",
+	TypeRadio:         "radio",
+	TypeFile:          "file",
+	TypeImage:         "image",
+	TypeSelect:        "select",
+	TypeSubmit:        "submit",
+	TypeReset:         "reset",
+	TypeButton:        "button",
+}
+
+// FieldTypeString returns the HTML input type for the field type. Integer
+// fields are number inputs that only accept whole numbers, and select
+// fields (which are not inputs) return "select".
+func FieldTypeString(f FieldType) string {
+	if f < 0 || int(f) >= len(fieldTypeNames) {
+		return "text"
+	}
+	return fieldTypeNames[f]
+}
+
+// Field holds everything needed to render a form field. Min, Max and
+// Step apply to number, range and date and time fields. Options are
+// used by select fields, and by checkbox and radio fields to render a
+// group of inputs sharing the same name.
+type Field struct {
+	ID          string
+	Name        string
+	Kind        FieldType
+	Value       string
+	Label       string
+	HelpText    string
+	MinLen      int
+	MaxLen      int
+	Min         string
+	Max         string
+	Step        string
+	Pattern     string
+	Accept      string
+	Src         string
+	Placeholder string
+	ErrorMsg    string
+	Options     []Option
+	Required    bool
+	Disabled    bool
+	Multiple    bool
+	Checked     bool
+}
+
+// Option is a single option of a select field, or a
+// single input of a checkbox or radio group
+type Option struct {
+	Value    string
+	Label    string
+	Selected bool
+}
+
+func (f Field) String() string {
+	h, err := renderField(f.FormField(), nil)
+	if err != nil {
+		panic("field template panic:" + err.Error())
+	}
+	return string(h)
+}
+
+// FormField returns the field as the form field type matching its Kind
+func (f Field) FormField() FormField {
+	switch f.Kind {
+	case TypeHidden:
+		return Hidden(f)
+	case TypeEmail:
+		return EmailField(f)
+	case TypeInteger:
+		return IntegerField(f)
+	case TypeNumber:
+		return Number(f)
+	case TypePassword:
+		return Password(f)
+	case TypeTel:
+		return Tel(f)
+	case TypeURL:
+		return Url(f)
+	case TypeSearch:
+		return Search(f)
+	case TypeDate:
+		return Date(f)
+	case TypeDatetimeLocal:
+		return DatetimeLocal(f)
+	case TypeMonth:
+		return Month(f)
+	case TypeWeek:
+		return Week(f)
+	case TypeTime:
+		return Time(f)
+	case TypeColor:
+		return Color(f)
+	case TypeRange:
+		return Range(f)
+	case TypeCheckbox:
+		return Checkbox(f)
+	case TypeRadio:
+		return Radio(f)
+	case TypeFile:
+		return File(f)
+	case TypeImage:
+		return Image(f)
+	case TypeSelect:
+		return Select(f)
+	case TypeSubmit:
+		return Submit(f)
+	case TypeReset:
+		return Reset(f)
+	case TypeButton:
+		return Button(f)
+	default:
+		return TextField(f)
+	}
+}
+
+type Hidden Field
+
+func (f Hidden) Type() FieldType {
+	return TypeHidden
+}
+
+type TextField Field
+
+func (f TextField) Type() FieldType {
+	return TypeText
+}
+
+type EmailField Field
+
+func (f EmailField) Type() FieldType {
+	return TypeEmail
+}
+
+// IntegerField is a number field that only accepts whole numbers
+type IntegerField Field
+
+func (f IntegerField) Type() FieldType {
+	return TypeInteger
+}
+
+type Text = TextField
+type Email = EmailField
+
+type Number Field
+
+func (f Number) Type() FieldType {
+	return TypeNumber
+}
+
+type Password Field
+
+func (f Password) Type() FieldType {
+	return TypePassword
+}
+
+type Tel Field
+
+func (f Tel) Type() FieldType {
+	return TypeTel
+}
+
+type Url Field
+
+func (f Url) Type() FieldType {
+	return TypeURL
+}
+
+type Search Field
+
+func (f Search) Type() FieldType {
+	return TypeSearch
+}
+
+type Date Field
+
+func (f Date) Type() FieldType {
+	return TypeDate
+}
+
+type DatetimeLocal Field
+
+func (f DatetimeLocal) Type() FieldType {
+	return TypeDatetimeLocal
+}
+
+type Month Field
+
+func (f Month) Type() FieldType {
+	return TypeMonth
+}
+
+type Week Field
+
+func (f Week) Type() FieldType {
+	return TypeWeek
+}
+
+type Time Field
+
+func (f Time) Type() FieldType {
+	return TypeTime
+}
+
+type Color Field
+
+func (f Color) Type() FieldType {
+	return TypeColor
+}
+
+type Range Field
+
+func (f Range) Type() FieldType {
+	return TypeRange
+}
+
+// Checkbox is a single checkbox when it has no options,
+// otherwise a group of checkboxes, one for each option
+type Checkbox Field
+
+func (f Checkbox) Type() FieldType {
+	return TypeCheckbox
+}
+
+// Radio is a group of radio buttons, one for each option
+type Radio Field
+
+func (f Radio) Type() FieldType {
+	return TypeRadio
+}
+
+type File Field
+
+func (f File) Type() FieldType {
+	return TypeFile
+}
+
+// Image is a graphical submit button showing the image at Src
+type Image Field
+
+func (f Image) Type() FieldType {
+	return TypeImage
+}
+
+// Select is a drop down list of options, or a list box
+// allowing more than one option to be chosen if Multiple
+type Select Field
+
+func (f Select) Type() FieldType {
+	return TypeSelect
+}
+
+type Submit Field
+
+func (f Submit) Type() FieldType {
+	return TypeSubmit
+}
+
+type Reset Field
+
+func (f Reset) Type() FieldType {
+	return TypeReset
+}
+
+type Button Field
+
+func (f Button) Type() FieldType {
+	return TypeButton
+}
+
+var fieldTemplate = template.Must(template.New("field").Parse(fieldStr))
+
+var fieldStr = `
+{{- define "attrs" }} name="{{ .Name }}" id="{{ .ID }}"
+	{{- if .F.Required }} required{{ end }}
+	{{- if .F.Disabled }} disabled{{ end }}
+	{{- if .F.Multiple }} multiple{{ end }}
+	{{- if .F.HelpText }} aria-describedby="{{ .ID }}-help"{{ end }}
+{{- end }}
+{{- define "input" }}<input type="{{ .Type }}" class="{{ .Class }}{{ if .Err }} is-invalid{{ end }}"{{ template "attrs" . }}
+	{{- if and .F.Value (ne .Type "password") }} value="{{ .F.Value }}"{{ end }}
+	{{- if .F.Placeholder }} placeholder="{{ .F.Placeholder }}"{{ end }}
+	{{- if .F.MinLen }} minlength="{{ .F.MinLen }}"{{ end }}
+	{{- if .F.MaxLen }} maxlength="{{ .F.MaxLen }}"{{ end }}
+	{{- if .F.Min }} min="{{ .F.Min }}"{{ end }}
+	{{- if .F.Max }} max="{{ .F.Max }}"{{ end }}
+	{{- if .F.Step }} step="{{ .F.Step }}"{{ end }}
+	{{- if .F.Pattern }} pattern="{{ .F.Pattern }}"{{ end }}
+	{{- if .F.Accept }} accept="{{ .F.Accept }}"{{ end }}
+	{{- if .F.Checked }} checked{{ end }}>
+{{- end }}
+{{- define "feedback" }}
+	{{- if .F.HelpText }}
+			<div id="{{ .ID }}-help" class="form-text">{{ .F.HelpText }}</div>
+	{{- end }}
+	{{- if .Err }}
+			<div class="invalid-feedback{{ if .F.Options }} d-block{{ end }}">{{ .Label }} {{ .Err }}</div>
+	{{- end }}
+{{- end }}
+{{- if eq .Type "hidden" -}}
+		<input type="hidden" name="{{ .Name }}" id="{{ .ID }}" value="{{ .F.Value }}">
+{{- else if or (eq .Type "submit") (eq .Type "reset") (eq .Type "button") -}}
+		<div class="mb-3">
+			<button type="{{ .Type }}" class="btn {{ if eq .Type "submit" }}btn-primary{{ else }}btn-secondary{{ end }}" name="{{ .Name }}" id="{{ .ID }}"{{ if .F.Value }} value="{{ .F.Value }}"{{ end }}{{ if .F.Disabled }} disabled{{ end }}>{{ .Label }}</button>
+		</div>
+{{- else if eq .Type "image" -}}
+		<div class="mb-3">
+			<input type="image" name="{{ .Name }}" id="{{ .ID }}" src="{{ .F.Src }}" alt="{{ .Label }}"{{ if .F.Disabled }} disabled{{ end }}>
+		</div>
+{{- else if and (or (eq .Type "checkbox") (eq .Type "radio")) .F.Options -}}
+		<div class="mb-3">
+			<div class="form-label">{{ .Label }}</div>
+	{{- range $i, $o := .F.Options }}
+			<div class="form-check">
+				<input type="{{ $.Type }}" class="form-check-input{{ if $.Err }} is-invalid{{ end }}" name="{{ $.Name }}" id="{{ $.ID }}-{{ $i }}" value="{{ $o.Value }}"
+					{{- if $o.Selected }} checked{{ end }}
+					{{- if $.F.Disabled }} disabled{{ end }}
+					{{- if and $.F.Required (eq $.Type "radio") }} required{{ end }}>
+				<label class="form-check-label" for="{{ $.ID }}-{{ $i }}">{{ or $o.Label $o.Value }}</label>
+			</div>
+	{{- end }}
+	{{- template "feedback" . }}
+		</div>
+{{- else if or (eq .Type "checkbox") (eq .Type "radio") -}}
+		<div class="mb-3 form-check">
+			{{ template "input" . }}
+			<label class="form-check-label" for="{{ .ID }}">{{ .Label }}</label>
+	{{- template "feedback" . }}
+		</div>
+{{- else if eq .Type "select" -}}
+		<div class="mb-3">
+			<label for="{{ .ID }}" class="form-label">{{ .Label }}</label>
+			<select class="form-select{{ if .Err }} is-invalid{{ end }}"{{ template "attrs" . }}>
+	{{- if .F.Placeholder }}
+				<option value=""{{ if not .Chosen }} selected{{ end }} disabled>{{ .F.Placeholder }}</option>
+	{{- end }}
+	{{- range .F.Options }}
+				<option value="{{ .Value }}"{{ if .Selected }} selected{{ end }}>{{ or .Label .Value }}</option>
+	{{- end }}
+			</select>
+	{{- template "feedback" . }}
+		</div>
+{{- else -}}
+		<div class="mb-3">
+			<label for="{{ .ID }}" class="form-label">{{ .Label }}</label>
+			{{ template "input" . }}
+	{{- template "feedback" . }}
+		</div>
+{{- end }}`
+
+// fieldData is what the field template is executed with
+type fieldData struct {
+	F      Field
+	Type   string
+	ID     string
+	Name   string
+	Label  string
+	Class  string
+	Err    string
+	Chosen bool
+}
+
+// renderField renders a single form field, along with its label, help
+// text and any error message (from errs, or the ErrorMsg of the field)
+func renderField(ff FormField, errs map[string]string) (template.HTML, error) {
+	f, err := asField(ff)
+	if err != nil {
+		return "", err
+	}
+	d := fieldData{
+		F:     f,
+		Type:  FieldTypeString(f.Kind),
+		ID:    strings.ToLower(f.ID),
+		Name:  strings.ToLower(f.Name),
+		Label: f.Label,
+		Class: "form-control",
+		Err:   f.ErrorMsg,
+	}
+	if d.ID == "" {
+		d.ID = d.Name
+	}
+	if d.Label == "" {
+		d.Label = strings.Title(f.Name)
+	}
+	if msg, ok := errs[d.Name]; ok {
+		d.Err = msg
+	}
+	switch f.Kind {
+	case TypeInteger:
+		if d.F.Step == "" {
+			d.F.Step = "1"
+		}
+	case TypeRange:
+		d.Class = "form-range"
+	case TypeColor:
+		d.Class = "form-control form-control-color"
+	case TypeCheckbox, TypeRadio:
+		d.Class = "form-check-input"
+	}
+	// mark the options matching the value as selected
+	if len(f.Options) > 0 {
+		d.F.Options = make([]Option, len(f.Options))
+		for i, o := range f.Options {
+			o.Selected = o.Selected || (f.Value != "" && o.Value == f.Value)
+			d.Chosen = d.Chosen || o.Selected
+			d.F.Options[i] = o
+		}
+	}
+	buf := new(bytes.Buffer)
+	err = fieldTemplate.Execute(buf, d)
+	if err != nil {
+		return "", err
+	}
+	return template.HTML(buf.String()), nil
+}
+
+var fieldReflectType = reflect.TypeOf(Field{})
+
+// asField returns the Field underlying a form field, which must be one
+// of the field types in this package (or another type based on Field)
+func asField(ff FormField) (Field, error) {
+	v := reflect.ValueOf(ff)
+	if !v.IsValid() || !v.Type().ConvertibleTo(fieldReflectType) {
+		return Field{}, fmt.Errorf("forms: %T is not based on forms.Field", ff)
+	}
+	f := v.Convert(fieldReflectType).Interface().(Field)
+	f.Kind = ff.Type()
+	return f, nil
+}
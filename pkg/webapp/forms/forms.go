@@ -2,28 +2,21 @@ package forms
 
 import (
 	"bytes"
-	"fmt"
 	"html/template"
-	"strings"
 )
 
 var formTemplate = template.Must(template.New("form").Funcs(template.FuncMap{
-	"lower": strings.ToLower,
-	"title": strings.Title,
-	"type":  FieldTypeString,
+	"field": renderField,
 }).Parse(formStr))
 
 var formStr = `<div class="row row-pad">
                 <br>
                 <legend>{{ .Name }}</legend>
                 <hr>
-                <form id="login-form" action="{{ .Action }}" method="post" novalidate="novalidate" autocomplete="off">
+                <form id="login-form" action="{{ .Action }}" method="post" novalidate="novalidate" autocomplete="off"{{ if .HasFile }} enctype="multipart/form-data"{{ end }}>
+				{{ .CSRFField }}
 				{{ range .Fields }}
-		<div class="mb-3">
-			<label for="{{ lower .Name }}" class="form-label">{{ title .Name }}</label>
-			<input type="{{ type .Type }}" class="form-control" name="{{ lower .Name }}" id="{{ lower .ID }}" aria-describedby="{{ lower .ID }}-help">
-    		{{ if ne .HelpText "" }}<div id="{{ lower .ID }}-help" class="form-text">{{ .HelpText }}</div>{{ end }}
-		</div>
+		{{ field . $.Errors }}
 				{{ end }}
                     <div class="d-grid gap-2 d-md-flex justify-content-md-end">
                         <button type="submit" class="btn btn-success me-md-2">{{ .SubmitText }}</button>
@@ -34,37 +27,6 @@ var formStr = `<div class="row row-pad">
                 </form>
             </div>`
 
-type Field struct {
-	ID          string
-	Name        string
-	Kind        FieldType
-	Value       string
-	Label       string
-	HelpText    string
-	MinLen      int
-	MaxLen      int
-	Placeholder string
-	ErrorMsg    string
-	Required    bool
-	Disabled    bool
-}
-
-func MakeFormFromStruct(p interface{}) (string, error) {
-	return "", nil
-}
-
-func (f Field) String() string {
-	strs := []string{
-		fmt.Sprintf(`<label for="%s" class="form-label">%s</label>`,
-			strings.ToLower(f.Name), strings.ToTitle(f.Name)),
-		fmt.Sprintf(`<input type="%s" class="form-control" name="%s" id="%s" aria-describedby="%s-help">`,
-			FieldTypeString(f.Kind), strings.ToLower(f.Name), strings.ToLower(f.ID), strings.ToLower(f.ID)),
-		fmt.Sprintf(`<div id="%s-help" class="form-text">%s</div>`,
-			strings.ToLower(f.ID), f.HelpText),
-	}
-	return strings.Join(strs, "\n")
-}
-
 type FormField interface {
 	Type() FieldType
 }
@@ -75,6 +37,49 @@ type Form struct {
 	Fields     []FormField
 	SubmitText string
 	HasCancel  bool
+	Errors     map[string]string
+	CSRFToken  string
+}
+
+// CSRFFieldName is the name of the hidden field the CSRF token is
+// rendered in, it matches the name webapp.CSRF checks by default
+var CSRFFieldName = "csrf_token"
+
+// WithCSRF returns a copy of the form that renders the supplied CSRF
+// token (from webapp.CSRFToken) in a hidden field. The form itself is
+// not changed, so it may be shared between requests.
+func (f *Form) WithCSRF(token string) *Form {
+	form := *f
+	form.CSRFToken = token
+	return &form
+}
+
+// CSRFField returns the hidden field holding the CSRF token of the
+// form, or nothing if the form has no token
+func (f *Form) CSRFField() template.HTML {
+	if f.CSRFToken == "" {
+		return ""
+	}
+	return template.HTML(`<input type="hidden" name="` + template.HTMLEscapeString(CSRFFieldName) +
+		`" value="` + template.HTMLEscapeString(f.CSRFToken) + `">`)
+}
+
+// SetErrors sets the error messages shown under each field, keyed by
+// the (lower case) field name. The map returned by the Map method of
+// webapp.ValidationErrors can be passed in directly.
+func (f *Form) SetErrors(errs map[string]string) {
+	f.Errors = errs
+}
+
+// HasFile reports whether the form has any file inputs, in which
+// case it is submitted as multipart/form-data
+func (f *Form) HasFile() bool {
+	for _, ff := range f.Fields {
+		if ff.Type() == TypeFile {
+			return true
+		}
+	}
+	return false
 }
 
 func (f *Form) String() string {
@@ -86,6 +91,11 @@ func (f *Form) String() string {
 	return buf.String()
 }
 
+// HTML returns the rendered form for use in templates
+func (f *Form) HTML() template.HTML {
+	return template.HTML(f.String())
+}
+
 func MakeForm(name, action, submitText string, hasCancel bool, fields ...FormField) *Form {
 	if submitText == "" {
 		submitText = "Submit"
@@ -102,92 +112,3 @@ func MakeForm(name, action, submitText string, hasCancel bool, fields ...FormFie
 	}
 	return form
 }
-
-type FieldType int
-
-const (
-	TypeHidden FieldType = iota
-	TypeText
-	TypeEmail
-	TypeInteger
-)
-
-func FieldTypeString(f FieldType) string {
-	switch f {
-	case TypeHidden:
-		return "hidden"
-	case TypeText:
-		return "text"
-	case TypeEmail:
-		return "email"
-	case TypeInteger:
-		return "integer"
-	default:
-		return "text"
-	}
-}
-
-type TextField Field
-
-func (f TextField) Type() FieldType {
-	return TypeText
-}
-
-type EmailField Field
-
-func (f EmailField) Type() FieldType {
-	return TypeEmail
-}
-
-type IntegerField Field
-
-func (f IntegerField) Type() FieldType {
-	return TypeEmail
-}
-
-type _BooleanField Field
-type _ChoiceField Field
-type _DateField Field
-type _DateTimeField Field
-type _FileField Field
-type _ImageField Field
-type _NumberField Field
-type _DecimalField Field
-type _MultipleChoiceField Field
-type _ Field
-type _ Field
-type _ Field
-type _ Field
-type _ Field
-type _ Field
-
-type Text Field
-type Email Field
-type Password Field
-type Tel Field
-type Date Field
-type Hidden Field
-type File Field
-
-type Checkbox Field
-type Color Field
-
-type DatetimeLocal Field
-
-type Image Field
-type Month Field
-type Number Field
-
-type Radio Field
-type Range Field
-
-type Search Field
-
-type Reset Field
-type Button Field
-
-type Submit Field
-
-type Time Field
-type Url Field
-type Week Field
@@ -0,0 +1,377 @@
+package forms
+
+import (
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// MaxMultipartMemory is the maximum number of bytes of a multipart
+// form that Process will hold in memory, the rest is stored on disk
+var MaxMultipartMemory int64 = 32 << 20
+
+// ErrInvalid is returned by Process when any field fails validation
+var ErrInvalid = errors.New("forms: invalid submission")
+
+// Values holds the typed values of a processed form, keyed by field name.
+// Integer fields hold an int64, number and range fields a float64, date
+// and time fields a time.Time, single checkboxes a bool, checkbox groups
+// and multiple selects a []string, and file fields a *multipart.FileHeader
+// (or []*multipart.FileHeader if Multiple.) Everything else is a string.
+// Empty optional fields are left out.
+type Values map[string]interface{}
+
+// String returns the value of a string field, or the formatted value of
+// any other field
+func (v Values) String(name string) string {
+	switch x := v[name].(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case []string:
+		return strings.Join(x, ",")
+	default:
+		return fmt.Sprint(x)
+	}
+}
+
+// Int returns the value of an integer field
+func (v Values) Int(name string) int64 {
+	n, _ := v[name].(int64)
+	return n
+}
+
+// Float returns the value of a number or range field
+func (v Values) Float(name string) float64 {
+	n, _ := v[name].(float64)
+	return n
+}
+
+// Bool returns whether a single checkbox was checked
+func (v Values) Bool(name string) bool {
+	b, _ := v[name].(bool)
+	return b
+}
+
+// Time returns the value of a date or time field
+func (v Values) Time(name string) time.Time {
+	t, _ := v[name].(time.Time)
+	return t
+}
+
+// Strings returns the values of a checkbox group or multiple select
+func (v Values) Strings(name string) []string {
+	switch x := v[name].(type) {
+	case []string:
+		return x
+	case string:
+		return []string{x}
+	}
+	return nil
+}
+
+// File returns the (first) file uploaded with a file field
+func (v Values) File(name string) *multipart.FileHeader {
+	switch x := v[name].(type) {
+	case *multipart.FileHeader:
+		return x
+	case []*multipart.FileHeader:
+		return x[0]
+	}
+	return nil
+}
+
+// Files returns the files uploaded with a file field
+func (v Values) Files(name string) []*multipart.FileHeader {
+	switch x := v[name].(type) {
+	case *multipart.FileHeader:
+		return []*multipart.FileHeader{x}
+	case []*multipart.FileHeader:
+		return x
+	}
+	return nil
+}
+
+// Process parses a submission of the form and checks every field against
+// its constraints. It always returns a copy of the form (even when the
+// submission can't be parsed) with the submitted values filled in, other
+// than passwords and files, ready to be rendered again. Disabled fields
+// are skipped, as browsers don't submit them. If every field is valid
+// the typed values are returned, otherwise the error is ErrInvalid and
+// each invalid field of the returned form has its ErrorMsg set, so
+// that it renders with its error. The form itself is not changed, so it
+// may be shared between requests.
+//
+//	vals, form, err := signupForm.Process(r)
+//	if err != nil {
+//		rd.HTML(w, http.StatusUnprocessableEntity, "signup.html", form)
+//		return
+//	}
+func (f *Form) Process(r *http.Request) (Values, *Form, error) {
+	form := *f
+	form.Errors = nil
+	var files map[string][]*multipart.FileHeader
+	if f.HasFile() {
+		err := r.ParseMultipartForm(MaxMultipartMemory)
+		if err != nil && !errors.Is(err, http.ErrNotMultipart) {
+			return nil, &form, fmt.Errorf("forms: parsing multipart form: %w", err)
+		}
+		if r.MultipartForm != nil {
+			files = r.MultipartForm.File
+		}
+	}
+	err := r.ParseForm()
+	if err != nil {
+		return nil, &form, fmt.Errorf("forms: parsing form: %w", err)
+	}
+	form.Fields = make([]FormField, len(f.Fields))
+	vals := make(Values)
+	valid := true
+	for i, ff := range f.Fields {
+		fld, err := asField(ff)
+		if err != nil {
+			return nil, &form, err
+		}
+		name := strings.ToLower(fld.Name)
+		fld.ErrorMsg = processField(&fld, r.PostForm[name], files[name], vals, name)
+		if fld.ErrorMsg != "" {
+			valid = false
+		}
+		form.Fields[i] = fld.FormField()
+	}
+	if !valid {
+		return nil, &form, ErrInvalid
+	}
+	return vals, &form, nil
+}
+
+// processField fills in the submitted values of a single field, and stores
+// its typed value in vals. It returns a message if the field is invalid.
+func processField(f *Field, ss []string, fhs []*multipart.FileHeader, vals Values, name string) string {
+	if f.Disabled {
+		// browsers don't submit disabled fields, so a value posted for
+		// one is ignored, and it keeps the value it was rendered with
+		return ""
+	}
+	var s string
+	if len(ss) > 0 {
+		s = strings.TrimSpace(ss[0])
+	}
+	switch f.Kind {
+	case TypeSubmit, TypeReset, TypeButton, TypeImage:
+		return ""
+	case TypeFile:
+		if len(fhs) == 0 {
+			if f.Required {
+				return "is required"
+			}
+			return ""
+		}
+		if f.Multiple {
+			vals[name] = fhs
+		} else {
+			vals[name] = fhs[0]
+		}
+		return ""
+	case TypeCheckbox, TypeRadio, TypeSelect:
+		if len(f.Options) > 0 {
+			return processChoice(f, ss, vals, name)
+		}
+		if f.Kind == TypeCheckbox {
+			f.Checked = len(ss) > 0
+			if f.Required && !f.Checked {
+				return "must be checked"
+			}
+			vals[name] = f.Checked
+			return ""
+		}
+	}
+	if f.Kind != TypePassword {
+		f.Value = s
+	}
+	if s == "" {
+		if f.Required {
+			return "is required"
+		}
+		return ""
+	}
+	if n := utf8.RuneCountInString(s); f.MinLen > 0 && n < f.MinLen {
+		return fmt.Sprintf("must have at least %d characters", f.MinLen)
+	}
+	if n := utf8.RuneCountInString(s); f.MaxLen > 0 && n > f.MaxLen {
+		return fmt.Sprintf("must have at most %d characters", f.MaxLen)
+	}
+	if f.Pattern != "" {
+		re, err := regexp.Compile("^(?:" + f.Pattern + ")$")
+		if err != nil || !re.MatchString(s) {
+			return "is not in the correct format"
+		}
+	}
+	var v interface{} = s
+	msg := ""
+	switch f.Kind {
+	case TypeEmail:
+		a, err := mail.ParseAddress(s)
+		if err != nil || a.Address != s {
+			msg = "must be a valid email address"
+		}
+	case TypeURL:
+		u, err := url.ParseRequestURI(s)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			msg = "must be a valid URL"
+		}
+	case TypeColor:
+		if !colorRe.MatchString(s) {
+			msg = "must be a valid color"
+		}
+	case TypeInteger:
+		v, msg = checkNumber(f, s, true)
+	case TypeNumber, TypeRange:
+		v, msg = checkNumber(f, s, false)
+	case TypeDate, TypeDatetimeLocal, TypeMonth, TypeWeek, TypeTime:
+		v, msg = checkTime(f, s)
+	}
+	if msg != "" {
+		return msg
+	}
+	vals[name] = v
+	return ""
+}
+
+var colorRe = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// processChoice handles fields with options, every submitted value
+// has to be one of the options
+func processChoice(f *Field, ss []string, vals Values, name string) string {
+	chosen := make(map[string]bool, len(ss))
+	for _, s := range ss {
+		chosen[s] = true
+	}
+	var picked []string
+	opts := make([]Option, len(f.Options))
+	for i, o := range f.Options {
+		o.Selected = chosen[o.Value]
+		if o.Selected {
+			picked = append(picked, o.Value)
+			delete(chosen, o.Value)
+		}
+		opts[i] = o
+	}
+	f.Options = opts
+	f.Value = ""
+	if len(chosen) > 0 {
+		return "is not a valid choice"
+	}
+	if len(picked) == 0 {
+		if f.Required {
+			return "is required"
+		}
+		return ""
+	}
+	if f.Multiple || f.Kind == TypeCheckbox {
+		vals[name] = picked
+		return ""
+	}
+	if len(picked) > 1 {
+		return "is not a valid choice"
+	}
+	f.Value = picked[0]
+	vals[name] = picked[0]
+	return ""
+}
+
+// checkNumber parses the value of a number field and checks it
+// against the Min and Max of the field
+func checkNumber(f *Field, s string, whole bool) (interface{}, string) {
+	var n float64
+	var v interface{}
+	if whole {
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, "must be a whole number"
+		}
+		n, v = float64(i), i
+	} else {
+		x, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, "must be a number"
+		}
+		n, v = x, x
+	}
+	if min, err := strconv.ParseFloat(f.Min, 64); err == nil && n < min {
+		return nil, "must be at least " + f.Min
+	}
+	if max, err := strconv.ParseFloat(f.Max, 64); err == nil && n > max {
+		return nil, "must be at most " + f.Max
+	}
+	return v, ""
+}
+
+// timeLayouts are the formats used by the date and time inputs
+var timeLayouts = map[FieldType]string{
+	TypeDate:          "2006-01-02",
+	TypeDatetimeLocal: "2006-01-02T15:04",
+	TypeMonth:         "2006-01",
+	TypeTime:          "15:04",
+}
+
+// checkTime parses the value of a date or time field and checks
+// it against the Min and Max of the field
+func checkTime(f *Field, s string) (interface{}, string) {
+	t, ok := parseTime(f.Kind, s)
+	if !ok {
+		return nil, "must be a valid " + strings.Replace(FieldTypeString(f.Kind), "-local", "", 1)
+	}
+	if min, ok := parseTime(f.Kind, f.Min); ok && t.Before(min) {
+		return nil, "must not be before " + f.Min
+	}
+	if max, ok := parseTime(f.Kind, f.Max); ok && t.After(max) {
+		return nil, "must not be after " + f.Max
+	}
+	return t, ""
+}
+
+// parseTime parses a value in the format used by the field type. Week
+// values, eg. "2021-W07", are returned as the Monday of the ISO week.
+func parseTime(kind FieldType, s string) (time.Time, bool) {
+	if s == "" {
+		return time.Time{}, false
+	}
+	if kind != TypeWeek {
+		layout := timeLayouts[kind]
+		t, err := time.Parse(layout, s)
+		if err != nil && kind == TypeDatetimeLocal {
+			// seconds are included when the step is below a minute
+			t, err = time.Parse("2006-01-02T15:04:05", s)
+		}
+		if err != nil && kind == TypeTime {
+			t, err = time.Parse("15:04:05", s)
+		}
+		return t, err == nil
+	}
+	i := strings.Index(s, "-W")
+	if i < 0 {
+		return time.Time{}, false
+	}
+	year, err1 := strconv.Atoi(s[:i])
+	week, err2 := strconv.Atoi(s[i+2:])
+	if err1 != nil || err2 != nil || week < 1 || week > 53 {
+		return time.Time{}, false
+	}
+	// the 4th of January is always in the first ISO week
+	t := time.Date(year, time.January, 4, 0, 0, 0, 0, time.UTC)
+	t = t.AddDate(0, 0, -((int(t.Weekday())+6)%7)+(week-1)*7)
+	if y, w := t.ISOWeek(); y != year || w != week {
+		return time.Time{}, false
+	}
+	return t, true
+}
@@ -0,0 +1,325 @@
+package forms
+
+import (
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// MakeFormFromStruct builds a form from the exported fields of the struct
+// (or pointer to a struct) p, pre-populated with the current values of the
+// struct. Field types are inferred from the kind and name of each field,
+// and the input names match those used by webapp.Bind (the `form` tag, or
+// the lower case field name.) Fields are configured with the `html` tag,
+// which holds comma separated options, with quoted values where needed:
+//
+//	Name string `html:"placeholder='your full name', help='Who are you?'"`
+//
+// The supported options are type, id, label, placeholder, help, value,
+// minlen, maxlen, min, max, step, pattern, accept, src, options (a comma
+// separated list of value:label pairs), required, disabled, multiple and
+// checked. Fields are required unless they
+// have required=false, and fields tagged `html:"-"` are skipped. The form
+// is named after the struct type, and has no action, so it posts back to
+// the page it was served from unless the Action is set.
+func MakeFormFromStruct(p interface{}) (*Form, error) {
+	v := reflect.ValueOf(p)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil, errors.New("forms: nil struct pointer")
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("forms: expected a struct, got %s", v.Kind())
+	}
+	fields, err := structFields(v)
+	if err != nil {
+		return nil, err
+	}
+	return MakeForm(splitWords(v.Type().Name()), "", "", false, fields...), nil
+}
+
+// structFields returns a form field for every usable field of the struct v
+func structFields(v reflect.Value) ([]FormField, error) {
+	var fields []FormField
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+			ff, err := structFields(v.Field(i))
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, ff...)
+			continue
+		}
+		tag, hasTag := sf.Tag.Lookup("html")
+		if sf.PkgPath != "" || tag == "-" || sf.Tag.Get("form") == "-" {
+			continue
+		}
+		kind, ok := inferType(sf)
+		if !ok && !hasTag {
+			continue
+		}
+		name := sf.Tag.Get("form")
+		if name == "" {
+			name = strings.ToLower(sf.Name)
+		}
+		f := Field{
+			ID:       name,
+			Name:     name,
+			Kind:     kind,
+			Label:    splitWords(sf.Name),
+			Value:    formatValue(v.Field(i), kind),
+			Required: true,
+		}
+		switch kind {
+		case TypeCheckbox:
+			// a required checkbox has to be checked
+			f.Required = false
+			f.Checked = f.Value == "true"
+			f.Value = "true"
+		case TypeFile:
+			f.Value = ""
+			f.Multiple = sf.Type.Kind() == reflect.Slice
+		case TypeNumber:
+			f.Step = "any"
+		}
+		opts, err := parseTag(tag)
+		if err != nil {
+			return nil, fmt.Errorf("forms: field %s: %w", sf.Name, err)
+		}
+		for _, opt := range opts {
+			err = f.setOption(opt[0], opt[1])
+			if err != nil {
+				return nil, fmt.Errorf("forms: field %s: %w", sf.Name, err)
+			}
+		}
+		fields = append(fields, f.FormField())
+	}
+	return fields, nil
+}
+
+// setOption applies a single html tag option to the field
+func (f *Field) setOption(key, val string) error {
+	var err error
+	switch key {
+	case "type":
+		kind, ok := parseFieldType(val)
+		if !ok {
+			return fmt.Errorf("unknown field type %q", val)
+		}
+		f.Kind = kind
+	case "id":
+		f.ID = val
+	case "label":
+		f.Label = val
+	case "placeholder":
+		f.Placeholder = val
+	case "help":
+		f.HelpText = val
+	case "value":
+		f.Value = val
+	case "minlen":
+		f.MinLen, err = strconv.Atoi(val)
+	case "maxlen":
+		f.MaxLen, err = strconv.Atoi(val)
+	case "required":
+		f.Required, err = strconv.ParseBool(val)
+	case "disabled":
+		f.Disabled, err = strconv.ParseBool(val)
+	case "multiple":
+		f.Multiple, err = strconv.ParseBool(val)
+	case "checked":
+		f.Checked, err = strconv.ParseBool(val)
+	case "min":
+		f.Min = val
+	case "max":
+		f.Max = val
+	case "step":
+		f.Step = val
+	case "pattern":
+		f.Pattern = val
+	case "accept":
+		f.Accept = val
+	case "src":
+		f.Src = val
+	case "options":
+		f.Options = parseOptions(val)
+	default:
+		return fmt.Errorf("unknown html tag option %q", key)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid value %q for %s", val, key)
+	}
+	return nil
+}
+
+// parseFieldType returns the field type for a type tag option
+func parseFieldType(s string) (FieldType, bool) {
+	if s == "integer" {
+		return TypeInteger, true
+	}
+	for i, name := range fieldTypeNames {
+		if name == s && FieldType(i) != TypeInteger {
+			return FieldType(i), true
+		}
+	}
+	return TypeText, false
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	fileHeaderType = reflect.TypeOf(&multipart.FileHeader{})
+)
+
+// inferType returns the field type for a struct field, based on its kind
+// and name, and false if the field has no sensible form representation
+func inferType(sf reflect.StructField) (FieldType, bool) {
+	t := sf.Type
+	if t == fileHeaderType || (t.Kind() == reflect.Slice && t.Elem() == fileHeaderType) {
+		return TypeFile, true
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	name := strings.ToLower(sf.Name)
+	if t == timeType {
+		if strings.Contains(name, "date") || strings.Contains(name, "birthday") {
+			return TypeDate, true
+		}
+		return TypeDatetimeLocal, true
+	}
+	switch t.Kind() {
+	case reflect.String:
+		switch {
+		case strings.Contains(name, "email"):
+			return TypeEmail, true
+		case strings.Contains(name, "password"):
+			return TypePassword, true
+		case strings.Contains(name, "phone") || strings.HasPrefix(name, "tel"):
+			return TypeTel, true
+		case strings.Contains(name, "url") || strings.Contains(name, "website"):
+			return TypeURL, true
+		case strings.Contains(name, "color") || strings.Contains(name, "colour"):
+			return TypeColor, true
+		}
+		return TypeText, true
+	case reflect.Bool:
+		return TypeCheckbox, true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return TypeInteger, true
+	case reflect.Float32, reflect.Float64:
+		return TypeNumber, true
+	}
+	return TypeText, false
+}
+
+// formatValue returns the value of a struct field as it should appear
+// in a field of the supplied type, zero values are left empty
+func formatValue(v reflect.Value, kind FieldType) string {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	if v.IsZero() {
+		return ""
+	}
+	if t, ok := v.Interface().(time.Time); ok {
+		switch kind {
+		case TypeDate:
+			return t.Format("2006-01-02")
+		case TypeTime:
+			return t.Format("15:04")
+		}
+		return t.Format("2006-01-02T15:04")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// parseOptions parses the options tag option, which is a comma
+// separated list of values, each with an optional label after a
+// colon, eg. "options='r:Red, g:Green, b:Blue'"
+func parseOptions(s string) []Option {
+	var opts []Option
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		o := Option{Value: part, Label: part}
+		if i := strings.IndexByte(part, ':'); i > -1 {
+			o.Value, o.Label = strings.TrimSpace(part[:i]), strings.TrimSpace(part[i+1:])
+		}
+		opts = append(opts, o)
+	}
+	return opts
+}
+
+// parseTag splits an html tag into key and value pairs. Values may be
+// quoted with single quotes, in which case they may contain commas. A
+// key without a value, eg. "disabled", is given the value "true".
+func parseTag(tag string) ([][2]string, error) {
+	var opts [][2]string
+	s := strings.TrimSpace(tag)
+	for s != "" {
+		var key, val string
+		i := strings.IndexAny(s, "=,")
+		if i < 0 {
+			key, s = s, ""
+		} else {
+			key, s = s[:i], s[i:]
+		}
+		key = strings.TrimSpace(key)
+		if key == "" {
+			return nil, fmt.Errorf("invalid html tag %q", tag)
+		}
+		val = "true"
+		if strings.HasPrefix(s, "=") {
+			s = strings.TrimLeft(s[1:], " ")
+			if strings.HasPrefix(s, "'") {
+				end := strings.IndexByte(s[1:], '\'')
+				if end < 0 {
+					return nil, fmt.Errorf("unterminated quote in html tag %q", tag)
+				}
+				val, s = s[1:end+1], strings.TrimLeft(s[end+2:], " ")
+			} else {
+				end := strings.IndexByte(s, ',')
+				if end < 0 {
+					end = len(s)
+				}
+				val, s = strings.TrimSpace(s[:end]), s[end:]
+			}
+		}
+		if s != "" && !strings.HasPrefix(s, ",") {
+			return nil, fmt.Errorf("invalid html tag %q", tag)
+		}
+		s = strings.TrimSpace(strings.TrimPrefix(s, ","))
+		opts = append(opts, [2]string{key, val})
+	}
+	return opts, nil
+}
+
+// splitWords splits a Go identifier into space separated
+// words, eg. "EmailAddress" becomes "Email Address"
+func splitWords(s string) string {
+	var sb strings.Builder
+	rs := []rune(s)
+	for i, r := range rs {
+		if i > 0 && unicode.IsUpper(r) &&
+			(unicode.IsLower(rs[i-1]) || (i+1 < len(rs) && unicode.IsLower(rs[i+1]))) {
+			sb.WriteByte(' ')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
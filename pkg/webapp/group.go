@@ -0,0 +1,168 @@
+package webapp
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Group is a set of routes sharing a common path prefix and middleware
+// chain. Routes added to a group are registered on the parent muxer with
+// their full paths, wrapped in the group's middleware.
+type Group struct {
+	mux    *Muxer
+	prefix string
+	chain  *Chain
+}
+
+// Group creates a new route group on the muxer for the supplied prefix.
+// Any middleware provided will wrap every handler added to the group.
+func (s *Muxer) Group(prefix string, mw ...Middleware) *Group {
+	return &Group{
+		mux:    s,
+		prefix: cleanPrefix(prefix),
+		chain:  NewChain(mw...),
+	}
+}
+
+// Group creates a nested route group, inheriting the prefix and
+// middleware of the parent group.
+func (g *Group) Group(prefix string, mw ...Middleware) *Group {
+	return &Group{
+		mux:    g.mux,
+		prefix: g.prefix + cleanPrefix(prefix),
+		chain:  g.chain.Append(mw...),
+	}
+}
+
+// Use appends middleware to the group. It only applies to
+// routes that are added to the group after it is called.
+func (g *Group) Use(mw ...Middleware) {
+	g.chain = g.chain.Append(mw...)
+}
+
+// Prefix returns the full path prefix of the group
+func (g *Group) Prefix() string {
+	return g.prefix
+}
+
+// Handle registers the handler for the given method and pattern,
+// relative to the prefix of the group. The pattern "/" refers to
+// the group prefix itself.
+func (g *Group) Handle(method string, pattern string, handler http.Handler) *Route {
+	if handler == nil {
+		panic("http: nil handler")
+	}
+	return g.mux.Handle(method, g.path(pattern), g.chain.Then(handler))
+}
+
+func (g *Group) HandleFunc(method, pattern string, handler func(http.ResponseWriter, *http.Request)) *Route {
+	if handler == nil {
+		panic("http: nil handler")
+	}
+	return g.Handle(method, pattern, http.HandlerFunc(handler))
+}
+
+func (g *Group) Forward(oldpattern string, newpattern string) *Route {
+	return g.Handle(http.MethodGet, oldpattern, http.RedirectHandler(newpattern, http.StatusTemporaryRedirect))
+}
+
+func (g *Group) Get(pattern string, handler http.Handler) *Route {
+	return g.Handle(http.MethodGet, pattern, handler)
+}
+
+func (g *Group) Post(pattern string, handler http.Handler) *Route {
+	return g.Handle(http.MethodPost, pattern, handler)
+}
+
+func (g *Group) Put(pattern string, handler http.Handler) *Route {
+	return g.Handle(http.MethodPut, pattern, handler)
+}
+
+func (g *Group) Delete(pattern string, handler http.Handler) *Route {
+	return g.Handle(http.MethodDelete, pattern, handler)
+}
+
+// ErrHandler registers an error handler for the group under the
+// "/error/" path of the group prefix, in the same way the muxer
+// registers MuxerConfig.ErrHandler under "/error/"
+func (g *Group) ErrHandler(handler http.Handler) *Route {
+	return g.Get("/error/*code", handler)
+}
+
+// NotFound registers a handler that is called for any request under
+// the group prefix that does not match a registered route. The most
+// specific (longest) prefix wins when groups are nested.
+func (g *Group) NotFound(handler http.Handler) {
+	if handler == nil {
+		panic("http: nil handler")
+	}
+	g.mux.lock.Lock()
+	defer g.mux.lock.Unlock()
+	g.mux.nf[g.prefix] = g.chain.Then(handler)
+}
+
+// path returns the full pattern for a pattern relative to the group
+func (g *Group) path(pattern string) string {
+	if pattern == "" || pattern == pathSeperator {
+		if g.prefix == "" {
+			return pathSeperator
+		}
+		return g.prefix
+	}
+	if pattern[0] != '/' {
+		pattern = pathSeperator + pattern
+	}
+	return g.prefix + pattern
+}
+
+// Mount attaches a sub muxer to the muxer under the supplied (static) prefix.
+// Requests for the prefix and anything below it are handed to the sub
+// muxer with the prefix removed from the path, so the sub muxer keeps
+// its own routes, error handler and 404 handling. Any middleware given
+// wraps the sub muxer. The routes of the sub muxer are included in the
+// route listings of the muxer with their full paths.
+func (s *Muxer) Mount(prefix string, sub *Muxer, mw ...Middleware) {
+	if sub == nil {
+		panic("http: nil sub muxer")
+	}
+	prefix = cleanPrefix(prefix)
+	if prefix == "" {
+		panic("http: invalid mount prefix")
+	}
+	h := NewChain(mw...).Then(mountHandler(prefix, sub))
+	s.Handle(methodAny, prefix, h)
+	s.Handle(methodAny, prefix+pathSeperator, h)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.mounts[prefix] = sub
+}
+
+// mountHandler strips the prefix from the request path before
+// handing the request off to the mounted handler
+func mountHandler(prefix string, h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		p := strings.TrimPrefix(r.URL.Path, prefix)
+		if !strings.HasPrefix(p, pathSeperator) {
+			p = pathSeperator + p
+		}
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = p
+		r2.URL.RawPath = ""
+		h.ServeHTTP(w, r2)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// cleanPrefix returns the prefix with a leading slash and
+// without a trailing slash. The root prefix becomes empty.
+func cleanPrefix(prefix string) string {
+	prefix = strings.Trim(prefix, pathSeperator)
+	if prefix == "" {
+		return ""
+	}
+	return pathSeperator + prefix
+}
@@ -0,0 +1,164 @@
+package webapp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ErrorRenderer writes an error response for the supplied status code
+type ErrorRenderer func(w http.ResponseWriter, r *http.Request, code int)
+
+// Problem is a problem details document as described by RFC 7807
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// NewProblem returns a problem document for the supplied status code
+// and request, using the HTTPCodesLongFormat text as the detail
+func NewProblem(r *http.Request, code int) *Problem {
+	return &Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(code),
+		Status:   code,
+		Detail:   HTTPCodesLongFormat[code],
+		Instance: r.URL.Path,
+	}
+}
+
+// WriteProblem writes the problem document as "application/problem+json"
+func WriteProblem(w http.ResponseWriter, p *Problem) error {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	return json.NewEncoder(w).Encode(p)
+}
+
+// DefaultErrorRenderer renders the default error page template, or a
+// problem document for clients that would rather have JSON
+func DefaultErrorRenderer(w http.ResponseWriter, r *http.Request, code int) {
+	if wantsJSON(r) {
+		_ = WriteProblem(w, NewProblem(r, code))
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	_ = defaultErrTmpl.Execute(w, errTmplData(code))
+}
+
+// Error writes an error response for the status code. Every error
+// status the muxer produces goes through here. If the muxer config has
+// an ErrRenderer it is used, otherwise clients asking for JSON get a
+// problem document and everyone else gets the page from the configured
+// ErrHandler (or the default error page, if there is no ErrHandler.)
+func (s *Muxer) Error(w http.ResponseWriter, r *http.Request, code int) {
+	if s.conf.ErrRenderer != nil {
+		s.conf.ErrRenderer(w, r, code)
+		return
+	}
+	if s.conf.ErrHandler == nil || wantsJSON(r) {
+		DefaultErrorRenderer(w, r, code)
+		return
+	}
+	// hand the error off to the error handler as if the
+	// client had requested the error page for the code
+	c := strconv.Itoa(code)
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = "/error/" + c
+	r2.URL.RawPath = ""
+	r2 = withParams(r2, map[string]string{"code": c})
+	s.conf.ErrHandler.ServeHTTP(&statusWriter{ResponseWriter: w, code: code}, r2)
+}
+
+// errorHandler returns a handler that writes an error for the status code
+func (s *Muxer) errorHandler(code int) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		s.Error(w, r, code)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// statusWriter forces the status code of a response, so that error
+// handlers written to serve an error page still set the error status
+type statusWriter struct {
+	http.ResponseWriter
+	code  int
+	wrote bool
+}
+
+func (w *statusWriter) WriteHeader(int) {
+	if w.wrote {
+		return
+	}
+	w.wrote = true
+	w.ResponseWriter.WriteHeader(w.code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if !w.wrote {
+		w.WriteHeader(w.code)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// wantsJSON reports whether the client prefers a JSON response over HTML
+func wantsJSON(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return false
+	}
+	var jq, hq float64 = -1, -1
+	for _, part := range strings.Split(accept, ",") {
+		mt, q := parseMediaRange(part)
+		switch {
+		case mt == "application/json" || mt == "application/problem+json" || strings.HasSuffix(mt, "+json"):
+			if q > jq {
+				jq = q
+			}
+		case mt == "text/html" || mt == "application/xhtml+xml":
+			if q > hq {
+				hq = q
+			}
+		}
+	}
+	return jq > 0 && jq > hq
+}
+
+// parseMediaRange returns the media type and quality value of a single
+// element of an Accept header, eg. "text/html;q=0.9"
+func parseMediaRange(s string) (string, float64) {
+	q := 1.0
+	parts := strings.Split(s, ";")
+	for _, param := range parts[1:] {
+		i := strings.IndexByte(param, '=')
+		if i < 0 || strings.TrimSpace(param[:i]) != "q" {
+			continue
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(param[i+1:]), 64)
+		if err == nil {
+			q = f
+		}
+	}
+	return strings.ToLower(strings.TrimSpace(parts[0])), q
+}
+
+func errTmplData(code int) interface{} {
+	return struct {
+		ErrorCode     int
+		ErrorText     string
+		ErrorTextLong string
+	}{
+		ErrorCode:     code,
+		ErrorText:     http.StatusText(code),
+		ErrorTextLong: HTTPCodesLongFormat[code],
+	}
+}
@@ -0,0 +1,555 @@
+package webapp
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrTokenMalformed is returned for tokens that are not JWTs this
+	// package can read
+	ErrTokenMalformed = errors.New("auth: malformed token")
+
+	// ErrTokenSignature is returned for tokens with a bad signature, or
+	// signed by an unknown key or algorithm
+	ErrTokenSignature = errors.New("auth: invalid token signature")
+
+	// ErrTokenExpired is returned for tokens that have expired
+	ErrTokenExpired = errors.New("auth: token expired")
+
+	// ErrTokenNotYetValid is returned for tokens used before their nbf
+	ErrTokenNotYetValid = errors.New("auth: token not valid yet")
+
+	// ErrTokenAudience is returned for tokens issued for someone else
+	ErrTokenAudience = errors.New("auth: token audience mismatch")
+
+	// ErrTokenIssuer is returned for tokens issued by someone else
+	ErrTokenIssuer = errors.New("auth: token issuer mismatch")
+)
+
+const (
+	// JWT signing algorithms
+	AlgHS256 = "HS256"
+	AlgEdDSA = "EdDSA"
+
+	// refreshKey marks the sessions holding refresh tokens
+	refreshKey = "_refresh"
+)
+
+// JWTKey is a key signing and verifying JWTs with one algorithm. Keys
+// have an ID, sent in the "kid" header, so that a new key can sign new
+// tokens while tokens signed with the old key are still accepted.
+type JWTKey struct {
+	ID      string
+	Alg     string
+	secret  []byte
+	private ed25519.PrivateKey
+	public  ed25519.PublicKey
+}
+
+// NewHS256Key returns a key signing with HMAC SHA-256. The secret must
+// be at least 32 bytes.
+func NewHS256Key(id string, secret []byte) *JWTKey {
+	if len(secret) < 32 {
+		panic("auth: HS256 secret must be at least 32 bytes")
+	}
+	return &JWTKey{ID: id, Alg: AlgHS256, secret: append([]byte(nil), secret...)}
+}
+
+// NewEd25519Key returns a key signing with Ed25519
+func NewEd25519Key(id string, private ed25519.PrivateKey) *JWTKey {
+	if len(private) != ed25519.PrivateKeySize {
+		panic("auth: invalid Ed25519 private key")
+	}
+	return &JWTKey{ID: id, Alg: AlgEdDSA, private: private, public: private.Public().(ed25519.PublicKey)}
+}
+
+// NewEd25519VerifyKey returns a key that only verifies Ed25519 tokens,
+// eg. those issued by another service
+func NewEd25519VerifyKey(id string, public ed25519.PublicKey) *JWTKey {
+	if len(public) != ed25519.PublicKeySize {
+		panic("auth: invalid Ed25519 public key")
+	}
+	return &JWTKey{ID: id, Alg: AlgEdDSA, public: public}
+}
+
+func (k *JWTKey) canSign() bool {
+	return k.secret != nil || k.private != nil
+}
+
+func (k *JWTKey) sign(data []byte) []byte {
+	if k.Alg == AlgEdDSA {
+		return ed25519.Sign(k.private, data)
+	}
+	mac := hmac.New(sha256.New, k.secret)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func (k *JWTKey) verify(data, sig []byte) bool {
+	if k.Alg == AlgEdDSA {
+		return ed25519.Verify(k.public, data, sig)
+	}
+	return hmac.Equal(sig, k.sign(data))
+}
+
+// Audience is the "aud" claim, which is either a string or an array
+type Audience []string
+
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = Audience{s}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(a))
+}
+
+// JWTClaims are the claims of the tokens issued by JWT. Times are in
+// seconds since the Unix epoch.
+type JWTClaims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp"`
+	IssuedAt  int64    `json:"iat"`
+	NotBefore int64    `json:"nbf"`
+	Role      string   `json:"role,omitempty"`
+}
+
+// jwtHeader is the JOSE header of a token
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+	Kid string `json:"kid,omitempty"`
+}
+
+// JWTConfig is a configuration object for a JWT
+type JWTConfig struct {
+	Keys     []*JWTKey     // Keys verify tokens by their "kid", the first one signs new tokens
+	Issuer   string        // Issuer is the "iss" of new tokens, and tokens from other issuers are rejected, if set
+	Audience string        // Audience is the "aud" of new tokens, and tokens without it are rejected, if set
+	TTL      time.Duration // TTL is how long access tokens last, the default is 15 minutes
+	Leeway   time.Duration // Leeway allows for clock skew when checking times, the default is 30 seconds
+	Realm    string        // Realm is the realm of the authentication challenge, the default is "webapp"
+
+	Sessions        *SessionStore // Sessions keeps refresh tokens, which are not issued if nil
+	RefreshTTL      time.Duration // RefreshTTL is how long an unused refresh token lasts, the default is 30 days
+	RefreshLifetime time.Duration // RefreshLifetime is how long refreshing can go on from the first refresh token, the default is 90 days
+	Users           AuthUser      // Users checks passwords at the token endpoint, and rechecks users on refresh if a UserLookup
+	Guard           *LoginGuard   // Guard slows down and locks out failing logins, the default is a LoginGuard with the default config
+	Muxer           *Muxer        // Muxer renders errors, it may be nil
+}
+
+// JWT issues and verifies signed JSON Web Tokens (RFC 7519) for API
+// clients, signed with HS256 or EdDSA (Ed25519). Access tokens are
+// stateless and short lived. Refresh tokens are sessions in the
+// SessionStore, so revoking one removes its session, but they have
+// their own timeouts, RefreshTTL and RefreshLifetime, rather than those
+// of browser sessions. Each refresh token is used once, and replaced
+// with a new one.
+type JWT struct {
+	*JWTConfig
+	keys map[string]*JWTKey
+}
+
+// claimsKey is the context key for the claims of a request
+type claimsKey struct{}
+
+// ClaimsFrom returns the claims of a request that has been through the
+// JWT middleware, or nil if it has not been
+func ClaimsFrom(r *http.Request) *JWTClaims {
+	c, _ := r.Context().Value(claimsKey{}).(*JWTClaims)
+	return c
+}
+
+// NewJWT returns a new JWT issuer and verifier. It panics without a key
+// that can sign, or with two keys with the same ID.
+func NewJWT(conf *JWTConfig) *JWT {
+	if conf == nil || len(conf.Keys) == 0 || !conf.Keys[0].canSign() {
+		panic("auth: JWT requires a signing key")
+	}
+	if conf.TTL == 0 {
+		conf.TTL = time.Duration(15) * time.Minute
+	}
+	if conf.Leeway == 0 {
+		conf.Leeway = time.Duration(30) * time.Second
+	}
+	if conf.Realm == "" {
+		conf.Realm = "webapp"
+	}
+	if conf.RefreshTTL == 0 {
+		conf.RefreshTTL = time.Duration(30*24) * time.Hour
+	}
+	if conf.RefreshLifetime == 0 {
+		conf.RefreshLifetime = time.Duration(90*24) * time.Hour
+	}
+	if conf.Users != nil && conf.Guard == nil {
+		gc := new(LoginGuardConfig)
+		if conf.Muxer != nil {
+			gc.Logger = conf.Muxer.Logger()
+		}
+		conf.Guard = NewLoginGuard(gc)
+	}
+	j := &JWT{JWTConfig: conf, keys: make(map[string]*JWTKey)}
+	for _, k := range conf.Keys {
+		if _, ok := j.keys[k.ID]; ok {
+			panic("auth: duplicate JWT key id " + strconv.Quote(k.ID))
+		}
+		j.keys[k.ID] = k
+	}
+	return j
+}
+
+// JWT returns a JWT issuer and verifier for the users and sessions of
+// the web app, sharing its login guard. The config may leave those out.
+func (app *WebApp) JWT(conf *JWTConfig) *JWT {
+	if conf == nil {
+		panic("auth: JWT requires a signing key")
+	}
+	if conf.Sessions == nil {
+		conf.Sessions = app.SessionStore
+	}
+	if conf.Users == nil {
+		conf.Users = app.AuthUser
+	}
+	if conf.Guard == nil && app.auth != nil {
+		conf.Guard = app.auth.Guard
+	}
+	if conf.Muxer == nil {
+		conf.Muxer = app.Muxer
+	}
+	return NewJWT(conf)
+}
+
+// Issue returns a new access token for the user, and its claims
+func (j *JWT) Issue(user *SystemUser) (string, *JWTClaims, error) {
+	now := time.Now()
+	c := &JWTClaims{
+		Subject:   user.Username,
+		Issuer:    j.Issuer,
+		ExpiresAt: now.Add(j.TTL).Unix(),
+		IssuedAt:  now.Unix(),
+		NotBefore: now.Unix(),
+		Role:      user.Role,
+	}
+	if j.Audience != "" {
+		c.Audience = Audience{j.Audience}
+	}
+	key := j.Keys[0]
+	h, err := json.Marshal(jwtHeader{Alg: key.Alg, Typ: "JWT", Kid: key.ID})
+	if err != nil {
+		return "", nil, err
+	}
+	p, err := json.Marshal(c)
+	if err != nil {
+		return "", nil, err
+	}
+	data := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(p)
+	return data + "." + base64.RawURLEncoding.EncodeToString(key.sign([]byte(data))), c, nil
+}
+
+// Verify checks the signature, times, issuer and audience of the token,
+// and returns its claims. The algorithm must be that of the key named by
+// the "kid" header, which may only be left out if there is one key. The
+// "exp" claim is required, while a token without "nbf" is valid at once,
+// as RFC 7519 makes it optional.
+func (j *JWT) Verify(token string) (*JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenMalformed
+	}
+	hb, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+	var h jwtHeader
+	if err := json.Unmarshal(hb, &h); err != nil {
+		return nil, ErrTokenMalformed
+	}
+	key, ok := j.keys[h.Kid]
+	if !ok && h.Kid == "" && len(j.Keys) == 1 {
+		key, ok = j.Keys[0], true
+	}
+	if !ok || h.Alg != key.Alg {
+		return nil, ErrTokenSignature
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+	if !key.verify([]byte(parts[0]+"."+parts[1]), sig) {
+		return nil, ErrTokenSignature
+	}
+	pb, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+	c := new(JWTClaims)
+	if err := json.Unmarshal(pb, c); err != nil || c.Subject == "" || c.ExpiresAt == 0 {
+		return nil, ErrTokenMalformed
+	}
+	now := time.Now()
+	if !now.Before(time.Unix(c.ExpiresAt, 0).Add(j.Leeway)) {
+		return nil, ErrTokenExpired
+	}
+	if now.Add(j.Leeway).Before(time.Unix(c.NotBefore, 0)) {
+		return nil, ErrTokenNotYetValid
+	}
+	if j.Issuer != "" && c.Issuer != j.Issuer {
+		return nil, ErrTokenIssuer
+	}
+	if j.Audience != "" && !grants(c.Audience, j.Audience) {
+		return nil, ErrTokenAudience
+	}
+	return c, nil
+}
+
+// Middleware returns middleware authenticating requests by the Bearer
+// access token in their Authorization header. The user is put into the
+// request context (see UserFrom and ClaimsFrom), where the Authorizer
+// also looks. Requests without a valid token get a 401.
+func (j *JWT) Middleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			h := r.Header.Get("Authorization")
+			if len(h) < 7 || !strings.EqualFold(h[:7], "Bearer ") {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="`+j.Realm+`"`)
+				j.error(w, r, http.StatusUnauthorized)
+				return
+			}
+			c, err := j.Verify(strings.TrimSpace(h[7:]))
+			if err != nil {
+				desc := strings.TrimPrefix(err.Error(), "auth: ")
+				w.Header().Set("WWW-Authenticate", `Bearer realm="`+j.Realm+`", error="invalid_token", error_description="`+desc+`"`)
+				j.error(w, r, http.StatusUnauthorized)
+				return
+			}
+			user := &SystemUser{Username: c.Subject, Role: c.Role, IsActive: true}
+			ctx := context.WithValue(r.Context(), userKey{}, user)
+			ctx = context.WithValue(ctx, claimsKey{}, c)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// IssueRefresh returns a new refresh token for the user, stored as a
+// session in the Sessions store
+func (j *JWT) IssueRefresh(user *SystemUser) (string, error) {
+	if j.Sessions == nil {
+		return "", errors.New("auth: refresh tokens require sessions")
+	}
+	sess := j.Sessions.New()
+	sess.Set(refreshKey, "1")
+	sess.Set(SessionRoleKey, user.Role)
+	sess.Set(SessionUsernameKey, user.Username)
+	return j.saveRefresh(sess)
+}
+
+// Refresh uses up the refresh token, and returns a new access token and
+// a new refresh token for its user. The user is looked up again if the
+// Users are a UserLookup, so disabled users can't refresh, and the new
+// tokens get the role the user has now.
+func (j *JWT) Refresh(refresh string) (string, string, *JWTClaims, error) {
+	sess, ok := j.loadRefresh(refresh)
+	if !ok {
+		return "", "", nil, ErrInvalidToken
+	}
+	username, _ := sessionString(sess, SessionUsernameKey)
+	role, _ := sessionString(sess, SessionRoleKey)
+	user := &SystemUser{Username: username, Role: role, IsActive: true}
+	if lookup, ok := j.Users.(UserLookup); ok {
+		u, err := lookup.User(username)
+		if err != nil || !u.IsActive {
+			j.Sessions.delete(sess.ID())
+			return "", "", nil, ErrInvalidToken
+		}
+		user = u
+		sess.Set(SessionRoleKey, user.Role)
+	}
+	access, c, err := j.Issue(user)
+	if err != nil {
+		return "", "", nil, err
+	}
+	// a new id for the session, so the old refresh token is removed, but
+	// the RefreshLifetime still counts from the first refresh token
+	sess.rotate()
+	refresh, err = j.saveRefresh(sess)
+	if err != nil {
+		return "", "", nil, err
+	}
+	return access, refresh, c, nil
+}
+
+// RevokeRefresh removes the session of the refresh token, if it exists
+func (j *JWT) RevokeRefresh(refresh string) {
+	if sess, ok := j.loadRefresh(refresh); ok {
+		j.Sessions.delete(sess.ID())
+	}
+}
+
+// saveRefresh stores the refresh session and returns its token, which
+// is the session id signed with the session keys
+func (j *JWT) saveRefresh(sess *Session) (string, error) {
+	if oldID := sess.renewBy(j.refreshExpiry); oldID != "" {
+		j.Sessions.delete(oldID)
+	}
+	if sess.expired() {
+		// the RefreshLifetime has been reached
+		j.Sessions.delete(sess.ID())
+		return "", ErrInvalidToken
+	}
+	if !j.Sessions.store(sess) {
+		return "", errors.New("auth: storing refresh token failed")
+	}
+	return j.Sessions.codec.encode(refreshKey, []byte(sess.ID()))
+}
+
+// refreshExpiry returns when a refresh token issued now expires, for a
+// refresh session created at the time
+func (j *JWT) refreshExpiry(created time.Time) time.Time {
+	expires := time.Now().Add(j.RefreshTTL)
+	if max := created.Add(j.RefreshLifetime); max.Before(expires) {
+		return max
+	}
+	return expires
+}
+
+// loadRefresh returns the session of the refresh token
+func (j *JWT) loadRefresh(refresh string) (*Session, bool) {
+	if j.Sessions == nil || refresh == "" {
+		return nil, false
+	}
+	id, err := j.Sessions.codec.decode(refreshKey, refresh, j.RefreshTTL)
+	if err != nil {
+		return nil, false
+	}
+	sess, ok := j.Sessions.load(string(id))
+	if !ok || !sess.Has(refreshKey) {
+		return nil, false
+	}
+	return sess, true
+}
+
+// tokenResponse is the response of the token endpoint (RFC 6749 5.1)
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// HandleToken returns the token endpoint, which issues tokens on POST
+// in the manner of OAuth 2.0 (RFC 6749), taking form values. With the
+// "password" grant_type it checks the "username" and "password" against
+// the Users, and with the "refresh_token" grant_type it uses up the
+// "refresh_token" (see Refresh.) It responds with JSON holding an access
+// token and, if there are Sessions, a refresh token.
+func (j *JWT) HandleToken() http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			j.error(w, r, http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		var res tokenResponse
+		var c *JWTClaims
+		var err error
+		switch r.PostFormValue("grant_type") {
+		case "password":
+			if j.Users == nil {
+				j.tokenError(w, http.StatusBadRequest, "unsupported_grant_type")
+				return
+			}
+			un, pw, addr := r.PostFormValue("username"), r.PostFormValue("password"), remoteAddr(r)
+			if wait, ok := j.Guard.Check(un, addr); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				j.tokenError(w, http.StatusTooManyRequests, "invalid_grant")
+				return
+			}
+			user, ok := j.Users.Authenticate(un, pw)
+			if !ok {
+				j.Guard.Fail(un, addr)
+				j.tokenError(w, http.StatusBadRequest, "invalid_grant")
+				return
+			}
+			j.Guard.Succeed(un, addr)
+			if res.AccessToken, c, err = j.Issue(user); err == nil && j.Sessions != nil {
+				res.RefreshToken, err = j.IssueRefresh(user)
+			}
+		case "refresh_token":
+			if j.Sessions == nil {
+				j.tokenError(w, http.StatusBadRequest, "unsupported_grant_type")
+				return
+			}
+			res.AccessToken, res.RefreshToken, c, err = j.Refresh(r.PostFormValue("refresh_token"))
+			if errors.Is(err, ErrInvalidToken) {
+				j.tokenError(w, http.StatusBadRequest, "invalid_grant")
+				return
+			}
+		default:
+			j.tokenError(w, http.StatusBadRequest, "unsupported_grant_type")
+			return
+		}
+		if err != nil {
+			if j.Muxer != nil && j.Muxer.withLogging {
+				j.Muxer.logger.Error("auth: issuing token: %s\n", err)
+			}
+			j.error(w, r, http.StatusInternalServerError)
+			return
+		}
+		res.TokenType = "Bearer"
+		res.ExpiresIn = c.ExpiresAt - c.IssuedAt
+		w.Header().Set("Content-Type", MimeJSON+"; charset=utf-8")
+		_ = json.NewEncoder(w).Encode(res)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// HandleRevoke returns the revocation endpoint (RFC 7009), which removes
+// the refresh token in the "token" form value on POST. It responds with
+// a 200 whether or not the token was valid.
+func (j *JWT) HandleRevoke() http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			j.error(w, r, http.StatusMethodNotAllowed)
+			return
+		}
+		j.RevokeRefresh(r.PostFormValue("token"))
+		w.WriteHeader(http.StatusOK)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// tokenError writes an OAuth 2.0 error response (RFC 6749 5.2)
+func (j *JWT) tokenError(w http.ResponseWriter, code int, e string) {
+	w.Header().Set("Content-Type", MimeJSON+"; charset=utf-8")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": e})
+}
+
+func (j *JWT) error(w http.ResponseWriter, r *http.Request, code int) {
+	if j.Muxer == nil {
+		DefaultErrorRenderer(w, r, code)
+		return
+	}
+	j.Muxer.Error(w, r, code)
+}
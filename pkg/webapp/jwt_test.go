@@ -0,0 +1,268 @@
+package webapp
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signJWT makes a token with any header and claims
+func signJWT(k *JWTKey, h jwtHeader, claims map[string]interface{}) string {
+	hb, _ := json.Marshal(h)
+	cb, _ := json.Marshal(claims)
+	data := base64.RawURLEncoding.EncodeToString(hb) + "." + base64.RawURLEncoding.EncodeToString(cb)
+	return data + "." + base64.RawURLEncoding.EncodeToString(k.sign([]byte(data)))
+}
+
+func TestJWTVerify(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	ed := NewEd25519Key("ed", priv)
+	hs := NewHS256Key("hs", []byte(strings.Repeat("k", 32)))
+	stranger := NewHS256Key("hs", []byte(strings.Repeat("x", 32)))
+	j := NewJWT(&JWTConfig{Keys: []*JWTKey{ed, hs}, Issuer: "me", Audience: "api", Leeway: time.Minute})
+
+	now := time.Now().Unix()
+	claims := func(changes map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{"sub": "bob", "iss": "me", "aud": "api", "exp": now + 60, "iat": now, "nbf": now, "role": "admin"}
+		for k, v := range changes {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+	edh := jwtHeader{Alg: AlgEdDSA, Kid: "ed"}
+	hsh := jwtHeader{Alg: AlgHS256, Kid: "hs"}
+	issued, _, _ := j.Issue(&SystemUser{Username: "bob", Role: "admin"})
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"issued", issued, nil},
+		{"old key", signJWT(hs, hsh, claims(nil)), nil},
+		{"audience in an array", signJWT(ed, edh, claims(map[string]interface{}{"aud": []string{"web", "api"}})), nil},
+		{"expired within the leeway", signJWT(ed, edh, claims(map[string]interface{}{"exp": now - 30})), nil},
+		{"expired", signJWT(ed, edh, claims(map[string]interface{}{"exp": now - 90})), ErrTokenExpired},
+		{"not before, within the leeway", signJWT(ed, edh, claims(map[string]interface{}{"nbf": now + 30})), nil},
+		{"not before", signJWT(ed, edh, claims(map[string]interface{}{"nbf": now + 90})), ErrTokenNotYetValid},
+		{"without nbf", signJWT(ed, edh, claims(map[string]interface{}{"nbf": nil})), nil},
+		{"without exp", signJWT(ed, edh, claims(map[string]interface{}{"exp": nil})), ErrTokenMalformed},
+		{"without sub", signJWT(ed, edh, claims(map[string]interface{}{"sub": nil})), ErrTokenMalformed},
+		{"other audience", signJWT(ed, edh, claims(map[string]interface{}{"aud": "web"})), ErrTokenAudience},
+		{"without audience", signJWT(ed, edh, claims(map[string]interface{}{"aud": nil})), ErrTokenAudience},
+		{"other issuer", signJWT(ed, edh, claims(map[string]interface{}{"iss": "them"})), ErrTokenIssuer},
+		{"unknown kid", signJWT(ed, jwtHeader{Alg: AlgEdDSA, Kid: "gone"}, claims(nil)), ErrTokenSignature},
+		{"kid left out with two keys", signJWT(ed, jwtHeader{Alg: AlgEdDSA}, claims(nil)), ErrTokenSignature},
+		{"alg of another key", signJWT(hs, jwtHeader{Alg: AlgHS256, Kid: "ed"}, claims(nil)), ErrTokenSignature},
+		{"alg none", signJWT(hs, jwtHeader{Alg: "none", Kid: "hs"}, claims(nil)), ErrTokenSignature},
+		{"signed by a stranger", signJWT(stranger, hsh, claims(nil)), ErrTokenSignature},
+		{"tampered claims", issued[:strings.LastIndexByte(issued, '.')-2] + "xx" + issued[strings.LastIndexByte(issued, '.'):], ErrTokenSignature},
+		{"two parts", "a.b", ErrTokenMalformed},
+		{"garbage header", "!!.b.c", ErrTokenMalformed},
+	}
+	for _, tt := range tests {
+		c, err := j.Verify(tt.token)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+			continue
+		}
+		if err == nil && (c.Subject != "bob" || c.Role != "admin") {
+			t.Errorf("%s: claims %+v", tt.name, c)
+		}
+	}
+
+	// with one key, the kid may be left out
+	one := NewJWT(&JWTConfig{Keys: []*JWTKey{hs}})
+	if _, err := one.Verify(signJWT(hs, jwtHeader{Alg: AlgHS256}, claims(nil))); err != nil {
+		t.Errorf("kid left out with one key: %v", err)
+	}
+}
+
+func TestJWTConfig(t *testing.T) {
+	hs := NewHS256Key("hs", []byte(strings.Repeat("k", 32)))
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	tests := []struct {
+		name string
+		fn   func()
+	}{
+		{"short HS256 secret", func() { NewHS256Key("hs", []byte("short")) }},
+		{"no keys", func() { NewJWT(&JWTConfig{}) }},
+		{"verify only key first", func() {
+			NewJWT(&JWTConfig{Keys: []*JWTKey{NewEd25519VerifyKey("ed", priv.Public().(ed25519.PublicKey))}})
+		}},
+		{"duplicate kid", func() { NewJWT(&JWTConfig{Keys: []*JWTKey{hs, hs}}) }},
+	}
+	for _, tt := range tests {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: no panic", tt.name)
+				}
+			}()
+			tt.fn()
+		}()
+	}
+}
+
+func TestJWTMiddleware(t *testing.T) {
+	j := NewJWT(&JWTConfig{Keys: []*JWTKey{NewHS256Key("hs", []byte(strings.Repeat("k", 32)))}})
+	token, _, _ := j.Issue(&SystemUser{Username: "bob", Role: RoleUser})
+	h := NewChain(j.Middleware(), NewAuthorizer(nil).RequireRole(RoleUser)).ThenFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(UserFrom(r).Username + " " + ClaimsFrom(r).Role))
+	})
+	tests := []struct {
+		name string
+		auth string
+		want int
+	}{
+		{"valid", "Bearer " + token, http.StatusOK},
+		{"missing", "", http.StatusUnauthorized},
+		{"basic", "Basic Ym9iOnB3", http.StatusUnauthorized},
+		{"invalid", "Bearer " + token + "x", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", tt.auth)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.want)
+		}
+		if tt.want == http.StatusOK && w.Body.String() != "bob user" {
+			t.Errorf("%s: body %q", tt.name, w.Body.String())
+		}
+		if tt.want == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: no challenge", tt.name)
+		}
+	}
+}
+
+func newTestJWT(conf *JWTConfig) (*JWT, *SystemSessionUser) {
+	users := NewSystemSessionUser()
+	users.Hasher = NewBcryptHasher(4)
+	users.Register("bob", "correct horse", RoleUser)
+	conf.Keys = []*JWTKey{NewHS256Key("hs", []byte(strings.Repeat("k", 32)))}
+	conf.Users = users
+	if conf.Sessions == nil {
+		conf.Sessions = NewSessionStore(&SessionConfig{Backend: NewMemorySessionKV()})
+	}
+	return NewJWT(conf), users
+}
+
+func TestJWTRefresh(t *testing.T) {
+	j, users := newTestJWT(new(JWTConfig))
+	first, err := j.IssueRefresh(&SystemUser{Username: "bob", Role: RoleUser})
+	if err != nil {
+		t.Fatal(err)
+	}
+	access, second, c, err := j.Refresh(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := j.Verify(access); err != nil || c.Subject != "bob" {
+		t.Fatalf("refreshed access token: %v", err)
+	}
+	// each refresh token is used once
+	if _, _, _, err := j.Refresh(first); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("refresh token reused after rotation: %v", err)
+	}
+	// the role is looked up again
+	v, _ := users.users.Load("bob")
+	v.(*SystemUser).Role = RoleAdmin
+	_, third, c, err := j.Refresh(second)
+	if err != nil || c.Role != RoleAdmin {
+		t.Fatalf("refresh after a role change: %v, role %q", err, c.Role)
+	}
+	j.RevokeRefresh(third)
+	if _, _, _, err := j.Refresh(third); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("revoked refresh token accepted: %v", err)
+	}
+	// disabled users can't refresh
+	fourth, _ := j.IssueRefresh(&SystemUser{Username: "bob"})
+	v.(*SystemUser).IsActive = false
+	if _, _, _, err := j.Refresh(fourth); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("disabled user refreshed: %v", err)
+	}
+	// session cookies are not refresh tokens
+	ss := j.Sessions
+	sess := ss.New()
+	sess.Set(SessionUsernameKey, "bob")
+	w := httptest.NewRecorder()
+	ss.Save(w, httptest.NewRequest("GET", "/", nil), sess)
+	if _, _, _, err := j.Refresh(w.Result().Cookies()[0].Value); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("session cookie used as a refresh token: %v", err)
+	}
+}
+
+func TestJWTRefreshTimeouts(t *testing.T) {
+	// the browser session times out long before the refresh token
+	ss := NewSessionStore(&SessionConfig{IdleTimeout: time.Minute, AbsoluteTimeout: time.Hour})
+	j, _ := newTestJWT(&JWTConfig{Sessions: ss, RefreshTTL: 48 * time.Hour, RefreshLifetime: 72 * time.Hour})
+	token, _ := j.IssueRefresh(&SystemUser{Username: "bob"})
+	sess, ok := j.loadRefresh(token)
+	if !ok {
+		t.Fatal("refresh token not found")
+	}
+	if d := time.Duration(sess.ExpiresIn()) * time.Second; d < 47*time.Hour {
+		t.Errorf("refresh session expires in %s, want the RefreshTTL", d)
+	}
+	// refreshing can't go on past the RefreshLifetime
+	created := time.Now().Add(-71 * time.Hour)
+	if e := j.refreshExpiry(created); !e.Equal(created.Add(72 * time.Hour)) {
+		t.Errorf("refresh expiry %s not capped by the RefreshLifetime", e)
+	}
+}
+
+func TestJWTHandleToken(t *testing.T) {
+	j, _ := newTestJWT(new(JWTConfig))
+	post := func(h http.Handler, form string) (int, map[string]interface{}) {
+		r := httptest.NewRequest("POST", "/token", strings.NewReader(form))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		m := map[string]interface{}{}
+		json.Unmarshal(w.Body.Bytes(), &m)
+		return w.Code, m
+	}
+	code, m := post(j.HandleToken(), "grant_type=password&username=bob&password=correct+horse")
+	if code != http.StatusOK || m["token_type"] != "Bearer" || m["refresh_token"] == nil {
+		t.Fatalf("password grant: %d %v", code, m)
+	}
+	refresh := m["refresh_token"].(string)
+	code, m = post(j.HandleToken(), "grant_type=refresh_token&refresh_token="+refresh)
+	if code != http.StatusOK || m["access_token"] == nil {
+		t.Fatalf("refresh grant: %d %v", code, m)
+	}
+	tests := []struct {
+		name  string
+		form  string
+		code  int
+		error string
+	}{
+		{"reused refresh token", "grant_type=refresh_token&refresh_token=" + refresh, http.StatusBadRequest, "invalid_grant"},
+		{"unknown grant", "grant_type=client_credentials", http.StatusBadRequest, "unsupported_grant_type"},
+		{"wrong password", "grant_type=password&username=al&password=wrong", http.StatusBadRequest, "invalid_grant"},
+		{"too soon after a failure", "grant_type=password&username=al&password=wrong", http.StatusTooManyRequests, "invalid_grant"},
+	}
+	for _, tt := range tests {
+		code, m := post(j.HandleToken(), tt.form)
+		if code != tt.code || m["error"] != tt.error {
+			t.Errorf("%s: %d %v, want %d %s", tt.name, code, m, tt.code, tt.error)
+		}
+	}
+	post(j.HandleRevoke(), "token="+m["refresh_token"].(string))
+	if code, _ := post(j.HandleToken(), "grant_type=refresh_token&refresh_token="+m["refresh_token"].(string)); code != http.StatusBadRequest {
+		t.Errorf("revoked refresh token: status %d", code)
+	}
+}
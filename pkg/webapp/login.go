@@ -0,0 +1,418 @@
+package webapp
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AuthConfig is a configuration object for the login, logout and
+// registration handlers of a WebApp (see WebApp.MountAuth)
+type AuthConfig struct {
+	Users AuthUser // Users holds the users, the default is an in memory SystemSessionUser
+
+	LoginPath    string // LoginPath is the login route, the default is "/login"
+	LogoutPath   string // LogoutPath is the logout route, the default is "/logout"
+	RegisterPath string // RegisterPath is the registration route, the default is "/register", "-" turns registration off
+
+	LoginTemplate    string // LoginTemplate is the login page template, the default is "login.html"
+	RegisterTemplate string // RegisterTemplate is the registration page template, the default is "register.html"
+
+	SuccessURL string // SuccessURL is where users go once logged in, unless they were sent to log in, the default is "/"
+	FailureURL string // FailureURL is where users go when a login fails, the default is the LoginPath
+	LogoutURL  string // LogoutURL is where users go once logged out, the default is the LoginPath
+
+	DefaultRole string          // DefaultRole is the role of users who register, the default is RoleUser
+	Policy      *PasswordPolicy // Policy is checked on registration, the default is DefaultPasswordPolicy
+
+	RememberFor    time.Duration // RememberFor is how long "remember me" logins last, the default is 30 days, negative turns them off
+	RememberCookie string        // RememberCookie is the name of the "remember me" cookie, the default is "remember_me"
+	RememberStore  SessionKV     // RememberStore keeps the "remember me" tokens, they are kept in memory if nil
+
+	Guard *LoginGuard // Guard slows down and locks out failing logins, the default is a LoginGuard with the default config
+}
+
+// checkAuthConfig sets the default values of the auth config
+func checkAuthConfig(conf *AuthConfig) *AuthConfig {
+	if conf == nil {
+		conf = new(AuthConfig)
+	}
+	if conf.Users == nil {
+		conf.Users = NewSystemSessionUser()
+	}
+	if conf.LoginPath == "" {
+		conf.LoginPath = "/login"
+	}
+	if conf.LogoutPath == "" {
+		conf.LogoutPath = "/logout"
+	}
+	if conf.RegisterPath == "" {
+		conf.RegisterPath = "/register"
+	}
+	if conf.LoginTemplate == "" {
+		conf.LoginTemplate = "login.html"
+	}
+	if conf.RegisterTemplate == "" {
+		conf.RegisterTemplate = "register.html"
+	}
+	if conf.SuccessURL == "" {
+		conf.SuccessURL = "/"
+	}
+	if conf.FailureURL == "" {
+		conf.FailureURL = conf.LoginPath
+	}
+	if conf.LogoutURL == "" {
+		conf.LogoutURL = conf.LoginPath
+	}
+	if conf.DefaultRole == "" {
+		conf.DefaultRole = RoleUser
+	}
+	if conf.Policy == nil {
+		conf.Policy = DefaultPasswordPolicy
+	}
+	if conf.RememberFor == 0 {
+		conf.RememberFor = time.Duration(30*24) * time.Hour
+	}
+	if conf.RememberCookie == "" {
+		conf.RememberCookie = "remember_me"
+	}
+	if conf.RememberStore == nil {
+		conf.RememberStore = NewMemorySessionKV()
+	}
+	return conf
+}
+
+// initAuth sets up the users, authorizer and "remember me" tokens of
+// the web app. "Remember me" is turned off if the users can't be looked
+// up by name.
+func (app *WebApp) initAuth(conf *AuthConfig) {
+	app.auth = checkAuthConfig(conf)
+	app.AuthUser = app.auth.Users
+	if app.auth.Guard == nil {
+		gc := new(LoginGuardConfig)
+		if app.Muxer != nil {
+			gc.Logger = app.Muxer.Logger()
+		}
+		app.auth.Guard = NewLoginGuard(gc)
+	}
+	app.Authz = NewAuthorizer(&AuthzConfig{
+		LoginURL: app.auth.LoginPath,
+		Sessions: app.SessionStore,
+		Muxer:    app.Muxer,
+	})
+	users, ok := app.AuthUser.(UserLookup)
+	if app.auth.RememberFor < 0 || !ok {
+		return
+	}
+	app.remember = &rememberer{
+		kv:     app.auth.RememberStore,
+		users:  users,
+		name:   app.auth.RememberCookie,
+		maxAge: app.auth.RememberFor,
+		conf:   app.SessionStore.SessionConfig,
+	}
+	if app.Muxer != nil {
+		app.remember.logger = app.Muxer.Logger()
+	}
+	go app.remember.gc()
+}
+
+// MountAuth adds the login, logout and registration routes to the muxer,
+// named "login", "logout" and "register", using the session, "remember
+// me" and CSRF middleware. Logging out takes a POST, so that other sites
+// can't log users out.
+func (app *WebApp) MountAuth() {
+	if app.Muxer == nil || app.SessionStore == nil {
+		panic("webapp: MountAuth requires a muxer and sessions")
+	}
+	chain := app.SessionChain().Append(app.CSRF())
+	login := chain.Then(app.HandleLogin())
+	app.Muxer.Get(app.auth.LoginPath, login).Name("login")
+	app.Muxer.Post(app.auth.LoginPath, login)
+	app.Muxer.Post(app.auth.LogoutPath, chain.Then(app.HandleLogout())).Name("logout")
+	if app.auth.RegisterPath != "-" {
+		register := chain.Then(app.HandleRegister())
+		app.Muxer.Get(app.auth.RegisterPath, register).Name("register")
+		app.Muxer.Post(app.auth.RegisterPath, register)
+	}
+}
+
+// HandleLogin serves the login page on GET, and logs users in on POST,
+// taking the "username" and "password" form values, and "remember" for
+// a "remember me" login. Users are sent on to the page they were asked
+// to log in for (see Authorizer.ReturnURL) or the SuccessURL. Failures
+// are added to the session as flash messages and sent to the FailureURL.
+// Clients asking for JSON get a status code instead of a redirect.
+//
+// Failed logins are tracked by the Guard, by username and by address,
+// and logins are refused while the username has to wait or either is
+// locked out, with a 429 and a Retry-After header for clients asking for
+// JSON.
+func (app *WebApp) HandleLogin() http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			app.renderAuthPage(w, r, app.auth.LoginTemplate)
+			return
+		case http.MethodPost:
+		default:
+			app.authError(w, r, http.StatusMethodNotAllowed)
+			return
+		}
+		// get posted form values
+		un := normalizeUsername(r.PostFormValue("username"))
+		pw := r.PostFormValue("password")
+		addr := remoteAddr(r)
+		// refuse to try while the username has to wait or either is locked out
+		if wait, ok := app.auth.Guard.Check(un, addr); !ok {
+			app.loginLimited(w, r, wait)
+			return
+		}
+		// attempt to authenticate
+		user, ok := app.AuthUser.Authenticate(un, pw)
+		if !ok {
+			app.auth.Guard.Fail(un, addr)
+			if wantsJSON(r) {
+				app.authError(w, r, http.StatusUnauthorized)
+				return
+			}
+			addFlash(r, FlashError, "Invalid username or password.")
+			http.Redirect(w, r, withReturnURL(app.auth.FailureURL, app.Authz, r), http.StatusSeeOther)
+			return
+		}
+		// otherwise, log the user in to their session
+		app.auth.Guard.Succeed(un, addr)
+		app.startSession(w, r, user)
+		if app.remember != nil && isChecked(r.PostFormValue("remember")) {
+			app.remember.issue(w, user.Username)
+		}
+		if wantsJSON(r) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		http.Redirect(w, r, app.Authz.ReturnURL(r, app.auth.SuccessURL), http.StatusSeeOther)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// loginLimited refuses a login attempt, telling the user how long to wait
+func (app *WebApp) loginLimited(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	secs := int(math.Ceil(wait.Seconds()))
+	if wantsJSON(r) {
+		w.Header().Set("Retry-After", strconv.Itoa(secs))
+		app.authError(w, r, http.StatusTooManyRequests)
+		return
+	}
+	msg := "Too many failed logins, please try again in " + strconv.Itoa(secs) + " seconds."
+	if secs > 90 {
+		msg = "Too many failed logins, please try again in " + strconv.Itoa((secs+59)/60) + " minutes."
+	}
+	addFlash(r, FlashWarning, msg)
+	http.Redirect(w, r, withReturnURL(app.auth.FailureURL, app.Authz, r), http.StatusSeeOther)
+}
+
+// HandleRegister serves the registration page on GET, and registers new
+// users on POST, taking the "username", "password" and "confirm" form
+// values. Passwords are checked against the Policy. New users get the
+// DefaultRole and are logged in, and problems are added to the session
+// as flash messages.
+func (app *WebApp) HandleRegister() http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			app.renderAuthPage(w, r, app.auth.RegisterTemplate)
+			return
+		case http.MethodPost:
+		default:
+			app.authError(w, r, http.StatusMethodNotAllowed)
+			return
+		}
+		// get posted form values
+		un := normalizeUsername(r.PostFormValue("username"))
+		pw := r.PostFormValue("password")
+		var problems []string
+		if un == "" {
+			problems = append(problems, "Please choose a username.")
+		}
+		if pw != r.PostFormValue("confirm") {
+			problems = append(problems, "The passwords do not match.")
+		}
+		var verrs ValidationErrors
+		if errors.As(app.auth.Policy.Check(un, pw), &verrs) {
+			for _, e := range verrs {
+				problems = append(problems, "The password "+e.Message+".")
+			}
+		}
+		if problems == nil {
+			err := app.AuthUser.Register(un, pw, app.auth.DefaultRole)
+			switch {
+			case errors.Is(err, ErrUserExists):
+				problems = append(problems, "That username is taken.")
+			case err != nil:
+				if app.Muxer != nil && app.Muxer.withLogging {
+					app.Muxer.logger.Error("auth: registering %q: %s\n", un, err)
+				}
+				problems = append(problems, "Registration failed, please try again.")
+			}
+		}
+		if problems != nil {
+			if wantsJSON(r) {
+				app.authError(w, r, http.StatusUnprocessableEntity)
+				return
+			}
+			for _, msg := range problems {
+				addFlash(r, FlashError, msg)
+			}
+			http.Redirect(w, r, app.auth.RegisterPath, http.StatusSeeOther)
+			return
+		}
+		// log the new user in
+		app.startSession(w, r, &SystemUser{Username: un, Role: app.auth.DefaultRole, IsActive: true})
+		if wantsJSON(r) {
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		addFlash(r, FlashSuccess, "Welcome, your account has been created.")
+		http.Redirect(w, r, app.auth.SuccessURL, http.StatusSeeOther)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// HandleLogout logs the user out on POST, destroying the session and any
+// "remember me" token, and sends them to the LogoutURL
+func (app *WebApp) HandleLogout() http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			app.authError(w, r, http.StatusMethodNotAllowed)
+			return
+		}
+		if app.remember != nil {
+			app.remember.forget(w, r)
+		}
+		if sess := SessionFrom(r); sess != nil {
+			sess.Destroy()
+		} else {
+			app.SessionStore.Save(w, r, nil)
+		}
+		if wantsJSON(r) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		http.Redirect(w, r, app.auth.LogoutURL, http.StatusSeeOther)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// SessionChain returns a chain of the session and "remember me"
+// middleware, for the routes of the web app that use sessions, eg.
+//
+//	secure := app.SessionChain().Append(app.Authz.RequireLogin())
+func (app *WebApp) SessionChain() *Chain {
+	if app.SessionStore == nil {
+		panic("webapp: SessionChain requires sessions")
+	}
+	return NewChain(app.SessionStore.Middleware(), app.RememberMe())
+}
+
+// RememberMe returns middleware logging users in with their "remember
+// me" token, when their session is not logged in. It must come after
+// the session middleware (see SessionChain). Each token is only used once, and the user is
+// given a new one.
+func (app *WebApp) RememberMe() Middleware {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			sess := SessionFrom(r)
+			if app.remember != nil && sess != nil && !sess.Has(SessionUsernameKey) {
+				if user, ok := app.remember.login(w, r); ok {
+					app.startSession(w, r, user)
+					app.remember.issue(w, user.Username)
+				}
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// startSession stores the user in the session of the request. Any
+// existing session is given a new id, removing the old one, so that a
+// session id fixed by an attacker before login is never authenticated.
+func (app *WebApp) startSession(w http.ResponseWriter, r *http.Request, user *SystemUser) {
+	// the session middleware saves the session itself
+	if sess := SessionFrom(r); sess != nil {
+		sess.Regenerate()
+		sess.Set(SessionRoleKey, user.Role)
+		sess.Set(SessionUsernameKey, user.Username)
+		return
+	}
+	sess, ok := app.SessionStore.Get(r)
+	if ok {
+		sess.Regenerate()
+	} else {
+		sess = app.SessionStore.New()
+	}
+	sess.Set(SessionRoleKey, user.Role)
+	sess.Set(SessionUsernameKey, user.Username)
+	app.SessionStore.Save(w, r, sess)
+}
+
+// renderAuthPage renders the login or registration page
+func (app *WebApp) renderAuthPage(w http.ResponseWriter, r *http.Request, name string) {
+	if app.TemplateCache == nil || app.TemplateCache.Lookup(name) == nil {
+		app.authError(w, r, http.StatusNotFound)
+		return
+	}
+	app.TemplateCache.ExecuteTemplate(w, name, map[string]interface{}{
+		"Request":  r,
+		"Remember": app.remember != nil,
+	})
+}
+
+func (app *WebApp) authError(w http.ResponseWriter, r *http.Request, code int) {
+	if app.Muxer == nil {
+		DefaultErrorRenderer(w, r, code)
+		return
+	}
+	app.Muxer.Error(w, r, code)
+}
+
+// addFlash adds a flash message to the session of the request, if it
+// has been through the session middleware
+func addFlash(r *http.Request, level FlashLevel, msg string) {
+	if sess := SessionFrom(r); sess != nil {
+		sess.AddFlash(level, msg)
+	}
+}
+
+// withReturnURL adds the return parameter of the request to u, so that
+// it is not lost when a login fails
+func withReturnURL(u string, az *Authorizer, r *http.Request) string {
+	next := az.ReturnURL(r, "")
+	if next == "" {
+		return u
+	}
+	sep := "?"
+	if strings.Contains(u, "?") {
+		sep = "&"
+	}
+	return u + sep + url.QueryEscape(az.ReturnParam) + "=" + url.QueryEscape(next)
+}
+
+// normalizeUsername returns the username as it is registered and looked
+// up, so that stray spaces typed at login don't make a different user
+func normalizeUsername(s string) string {
+	return strings.TrimSpace(s)
+}
+
+// isChecked reports whether a checkbox form value is checked
+func isChecked(v string) bool {
+	switch strings.ToLower(v) {
+	case "on", "true", "1", "yes":
+		return true
+	}
+	return false
+}
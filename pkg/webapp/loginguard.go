@@ -0,0 +1,294 @@
+package webapp
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// LoginGuardConfig is a configuration object for a LoginGuard
+type LoginGuardConfig struct {
+	MaxFailures     int           // MaxFailures is the number of failures that locks a username, the default is 5
+	AddrMaxFailures int           // AddrMaxFailures is the number of failures that locks an address, the default is 20
+	LockoutFor      time.Duration // LockoutFor is how long the first lockout lasts, doubling with each one, the default is 15 minutes
+	MaxLockoutFor   time.Duration // MaxLockoutFor is the longest lockout, the default is 24 hours
+	BaseDelay       time.Duration // BaseDelay is the wait after the first failure, doubling with each one, the default is 1 second
+	MaxDelay        time.Duration // MaxDelay is the longest wait between attempts, the default is 1 minute
+	Window          time.Duration // Window is how long failures are remembered, the default is 1 hour
+	Store           SessionKV     // Store keeps the failure counts, they are kept in memory if nil
+	Logger          *Logger       // Logger reports lockouts, if set
+}
+
+// LoginGuard tracks failed logins by username and by remote address, to
+// slow down password guessing. After each failure the username must wait
+// before trying again, twice as long each time, and after too many
+// failures it is locked out, for twice as long each time. A success
+// clears the failures of the username.
+//
+// Addresses are only counted, and locked out after many more failures,
+// as many users may share one address behind a NAT or proxy, so the
+// failures of one user don't slow down the others. The failures of an
+// address are forgotten a Window after the last one.
+//
+// Check counts an attempt as pending before the password is checked,
+// so concurrent attempts for a username can't all get past it, and Fail
+// or Succeed settle it. Failures are kept in a SessionKV, so a guard can
+// share a session backend (eg. a SQLiteSessionKV) with other processes,
+// though attempts are only serialized within a process. Keep in mind
+// that anyone can lock out a username, by failing to log in as it.
+type LoginGuard struct {
+	*LoginGuardConfig
+	mu sync.Mutex // mu serializes checking and updating the failure records
+}
+
+// loginFailures is the stored failure record of a username or address
+type loginFailures struct {
+	Count       int       `json:"count"`    // Count is the number of failures, kept through lockouts
+	Pending     int       `json:"pending"`  // Pending is the number of attempts being checked, for a username
+	Lockouts    int       `json:"lockouts"` // Lockouts is the number of lockouts so far
+	Last        time.Time `json:"last"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+// NewLoginGuard returns a new login guard
+func NewLoginGuard(conf *LoginGuardConfig) *LoginGuard {
+	if conf == nil {
+		conf = new(LoginGuardConfig)
+	}
+	if conf.MaxFailures == 0 {
+		conf.MaxFailures = 5
+	}
+	if conf.AddrMaxFailures == 0 {
+		conf.AddrMaxFailures = 20
+	}
+	if conf.LockoutFor == 0 {
+		conf.LockoutFor = time.Duration(15) * time.Minute
+	}
+	if conf.MaxLockoutFor == 0 {
+		conf.MaxLockoutFor = time.Duration(24) * time.Hour
+	}
+	if conf.BaseDelay == 0 {
+		conf.BaseDelay = time.Second
+	}
+	if conf.MaxDelay == 0 {
+		conf.MaxDelay = time.Minute
+	}
+	if conf.Window == 0 {
+		conf.Window = time.Hour
+	}
+	if conf.Store == nil {
+		conf.Store = NewMemorySessionKV()
+	}
+	g := &LoginGuard{LoginGuardConfig: conf}
+	go g.gc()
+	return g
+}
+
+// Check reports whether a login may be attempted for the username from
+// the address, and if not, how long until one may be. An allowed attempt
+// is counted as pending for the username, and makes other attempts for
+// it wait as a failure would, until it is settled by Fail or Succeed,
+// which the caller must then call. The address is only checked for a
+// lockout.
+func (g *LoginGuard) Check(username, addr string) (time.Duration, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	keys := g.keys(username, addr)
+	f := g.load(keys[0])
+	if f == nil {
+		f = new(loginFailures)
+	}
+	until := f.LockedUntil
+	if n := f.Count + f.Pending; n > 0 {
+		if next := f.Last.Add(g.delay(n)); next.After(until) {
+			until = next
+		}
+	}
+	if a := g.load(keys[1]); a != nil && a.LockedUntil.After(until) {
+		until = a.LockedUntil
+	}
+	if wait := until.Sub(now); wait > 0 {
+		return wait, false
+	}
+	f.Pending++
+	f.Last = now
+	g.store(keys[0], f)
+	return 0, true
+}
+
+// Fail settles an attempt allowed by Check as a failed login, and locks
+// the username or address out if they have failed too many times. Once
+// a lockout is over, the next failure locks them out again, for longer.
+func (g *LoginGuard) Fail(username, addr string) {
+	g.fail(username, addr, true)
+}
+
+// LockedOut reports whether the username or the address is locked out,
+// and if so, for how long. Unlike Check, it doesn't slow down attempts
+// or count them as pending, for logins that are made on every request,
+// such as HTTP Basic authentication, where a failure is then recorded
+// with CountFailure and a success needs no settling.
+func (g *LoginGuard) LockedOut(username, addr string) (time.Duration, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	var until time.Time
+	for _, key := range g.keys(username, addr) {
+		if f := g.load(key); f != nil && f.LockedUntil.After(until) {
+			until = f.LockedUntil
+		}
+	}
+	if wait := until.Sub(now); wait > 0 {
+		return wait, true
+	}
+	return 0, false
+}
+
+// CountFailure records a failed login that was not allowed by Check, as
+// Fail does, locking the username or address out if they have failed
+// too many times
+func (g *LoginGuard) CountFailure(username, addr string) {
+	g.fail(username, addr, false)
+}
+
+func (g *LoginGuard) fail(username, addr string, settle bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	keys := g.keys(username, addr)
+	for i, key := range keys {
+		f := g.load(key)
+		if f == nil {
+			f = new(loginFailures)
+		}
+		if settle && i == 0 && f.Pending > 0 {
+			f.Pending--
+		}
+		f.Count++
+		f.Last = now
+		max, what, who := g.MaxFailures, "user", username
+		if i == 1 {
+			max, what, who = g.AddrMaxFailures, "address", addr
+		}
+		if f.Count >= max && !f.LockedUntil.After(now) {
+			d := g.lockout(f.Lockouts)
+			f.Lockouts++
+			f.LockedUntil = now.Add(d)
+			if g.Logger != nil {
+				g.Logger.Warn("auth: locked out %s %q for %s after %d failed logins\n", what, who, d, f.Count)
+			}
+		}
+		g.store(key, f)
+	}
+}
+
+// Succeed settles an attempt allowed by Check as a successful login,
+// clearing the failures of the username. The failures of the address
+// are kept until they expire, as one address may be trying many
+// usernames.
+func (g *LoginGuard) Succeed(username, addr string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	keys := g.keys(username, addr)
+	if err := g.Store.Delete(keys[0]); err != nil {
+		g.logError("clearing failures", err)
+	}
+}
+
+// lockout returns how long a lockout lasts after n earlier lockouts
+func (g *LoginGuard) lockout(n int) time.Duration {
+	d := g.LockoutFor
+	for i := 0; i < n && d < g.MaxLockoutFor; i++ {
+		d *= 2
+	}
+	if d > g.MaxLockoutFor {
+		d = g.MaxLockoutFor
+	}
+	return d
+}
+
+// delay returns the wait after n failures
+func (g *LoginGuard) delay(n int) time.Duration {
+	d := g.BaseDelay
+	for i := 1; i < n && d < g.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > g.MaxDelay {
+		d = g.MaxDelay
+	}
+	return d
+}
+
+// keys returns the store keys of the username and the address. They are
+// hashed, as stores may only accept keys that look like session ids.
+func (g *LoginGuard) keys(username, addr string) [2]string {
+	u := sha256.Sum256([]byte("login-user:" + username))
+	a := sha256.Sum256([]byte("login-addr:" + addr))
+	return [2]string{
+		base64.RawURLEncoding.EncodeToString(u[:]),
+		base64.RawURLEncoding.EncodeToString(a[:]),
+	}
+}
+
+func (g *LoginGuard) load(key string) *loginFailures {
+	b, err := g.Store.Get(key)
+	if err != nil {
+		if !errors.Is(err, ErrSessionNotFound) {
+			g.logError("loading failures", err)
+		}
+		return nil
+	}
+	f := new(loginFailures)
+	if err := json.Unmarshal(b, f); err != nil {
+		g.logError("decoding failures", err)
+		return nil
+	}
+	return f
+}
+
+func (g *LoginGuard) store(key string, f *loginFailures) {
+	b, err := json.Marshal(f)
+	if err != nil {
+		g.logError("encoding failures", err)
+		return
+	}
+	expires := f.Last.Add(g.Window)
+	if f.LockedUntil.After(expires) {
+		expires = f.LockedUntil
+	}
+	if err := g.Store.Set(key, b, expires); err != nil {
+		g.logError("storing failures", err)
+	}
+}
+
+// gc removes expired records from stores that can do so, every hour
+func (g *LoginGuard) gc() {
+	if e, ok := g.Store.(SessionExpirer); ok {
+		if err := e.DeleteExpired(); err != nil {
+			g.logError("deleting expired failures", err)
+		}
+	}
+	time.AfterFunc(time.Hour, func() { g.gc() })
+}
+
+func (g *LoginGuard) logError(what string, err error) {
+	if g.Logger != nil {
+		g.Logger.Error("auth: login guard: %s: %s\n", what, err)
+	}
+}
+
+// remoteAddr returns the address of the client, without the port. The
+// X-Forwarded-For header is not trusted, as any client can set it.
+func remoteAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
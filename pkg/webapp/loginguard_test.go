@@ -0,0 +1,171 @@
+package webapp
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestGuard() *LoginGuard {
+	return NewLoginGuard(&LoginGuardConfig{
+		MaxFailures:     3,
+		AddrMaxFailures: 10,
+		BaseDelay:       time.Millisecond,
+		MaxDelay:        4 * time.Millisecond,
+		LockoutFor:      40 * time.Millisecond,
+		MaxLockoutFor:   time.Second,
+	})
+}
+
+func TestLoginGuardConcurrentAttempts(t *testing.T) {
+	g := NewLoginGuard(&LoginGuardConfig{MaxFailures: 5, BaseDelay: time.Second})
+	var allowed int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok := g.Check("bob", "10.0.0.1"); !ok {
+				return
+			}
+			atomic.AddInt32(&allowed, 1)
+			// a slow password check
+			time.Sleep(50 * time.Millisecond)
+			g.Fail("bob", "10.0.0.1")
+		}()
+	}
+	wg.Wait()
+	if allowed != 1 {
+		t.Fatalf("%d concurrent attempts allowed, want 1", allowed)
+	}
+}
+
+func TestLoginGuardBackoff(t *testing.T) {
+	g := NewLoginGuard(&LoginGuardConfig{BaseDelay: time.Minute})
+	tests := []struct {
+		name string
+		user string
+		addr string
+		fail bool
+		ok   bool
+	}{
+		{"first attempt", "bob", "10.0.0.1", true, true},
+		{"too soon after a failure", "bob", "10.0.0.1", false, false},
+		{"same user from elsewhere", "bob", "10.0.0.2", false, false},
+		{"same address for another user", "al", "10.0.0.1", false, true},
+		{"unrelated", "carol", "10.0.0.3", false, true},
+	}
+	for _, tt := range tests {
+		_, ok := g.Check(tt.user, tt.addr)
+		if ok != tt.ok {
+			t.Errorf("%s: Check = %v, want %v", tt.name, ok, tt.ok)
+		}
+		if ok {
+			if tt.fail {
+				g.Fail(tt.user, tt.addr)
+			} else {
+				g.Succeed(tt.user, tt.addr)
+			}
+		}
+	}
+}
+
+func TestLoginGuardLockout(t *testing.T) {
+	g := newTestGuard()
+	fail := func() {
+		for {
+			if _, ok := g.Check("bob", "10.0.0.1"); ok {
+				g.Fail("bob", "10.0.0.1")
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		fail()
+	}
+	wait, ok := g.Check("bob", "10.0.0.9")
+	if ok || wait < 30*time.Millisecond {
+		t.Fatalf("not locked out after 3 failures: wait %s", wait)
+	}
+	time.Sleep(wait)
+	// the count is kept, so the next failure locks out again, for longer
+	fail()
+	wait, ok = g.Check("bob", "10.0.0.9")
+	if ok || wait < 70*time.Millisecond {
+		t.Fatalf("second lockout not longer: wait %s", wait)
+	}
+}
+
+func TestLoginGuardAddress(t *testing.T) {
+	g := NewLoginGuard(&LoginGuardConfig{AddrMaxFailures: 3, BaseDelay: time.Minute, Window: 50 * time.Millisecond})
+	addr := "10.0.0.1"
+	// a failure each for a few users doesn't lock out the address
+	for _, user := range []string{"al", "bob"} {
+		if _, ok := g.Check(user, addr); !ok {
+			t.Fatalf("%s refused", user)
+		}
+		g.Fail(user, addr)
+	}
+	// successes neither clear the failures of the address nor keep them
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := g.Check("carol", addr); !ok {
+		t.Fatal("carol refused")
+	}
+	g.Succeed("carol", addr)
+	time.Sleep(30 * time.Millisecond)
+	// so the failures of the address have expired by now, and pending
+	// logins of other users don't hold anyone up either
+	users := []string{"dave", "erin", "frank"}
+	for _, user := range users {
+		if _, ok := g.Check(user, addr); !ok {
+			t.Fatalf("%s refused", user)
+		}
+	}
+	for _, user := range users {
+		g.Fail(user, addr)
+	}
+	if wait, ok := g.Check("gina", addr); ok || wait < time.Minute {
+		t.Fatalf("address not locked out after 3 failures: wait %s", wait)
+	}
+	if _, ok := g.Check("gina", "10.0.0.2"); !ok {
+		t.Fatal("lockout of the address applied elsewhere")
+	}
+}
+
+func TestLoginGuardSucceed(t *testing.T) {
+	g := newTestGuard()
+	if _, ok := g.Check("bob", "10.0.0.1"); !ok {
+		t.Fatal("first attempt refused")
+	}
+	g.Fail("bob", "10.0.0.1")
+	time.Sleep(2 * time.Millisecond)
+	if _, ok := g.Check("bob", "10.0.0.1"); !ok {
+		t.Fatal("attempt after the delay refused")
+	}
+	g.Succeed("bob", "10.0.0.1")
+	if _, ok := g.Check("bob", "10.0.0.2"); !ok {
+		t.Fatal("failures of the user kept after a success")
+	}
+	g.Succeed("bob", "10.0.0.2")
+}
+
+func TestLoginGuardDelay(t *testing.T) {
+	g := NewLoginGuard(&LoginGuardConfig{BaseDelay: time.Second, MaxDelay: 10 * time.Second})
+	tests := []struct {
+		n    int
+		want time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{50, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := g.delay(tt.n); got != tt.want {
+			t.Errorf("delay(%d) = %s, want %s", tt.n, got, tt.want)
+		}
+	}
+}
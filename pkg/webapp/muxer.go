@@ -14,51 +14,76 @@ import (
 	"time"
 )
 
+// methodAny is used to register a handler for every request method
+const methodAny = "*"
+
 type muxEntry struct {
-	method  string
-	pattern string
-	handler http.Handler
+	pattern  string
+	handlers map[string]http.Handler
+	segs     []segment
 }
 
-func (m muxEntry) String() string {
-	if m.method == http.MethodGet {
-		return fmt.Sprintf("[%s]&nbsp;&nbsp;&nbsp;&nbsp;<a href=\"%s\">%s</a>", m.method, m.pattern, m.pattern)
-	}
-	if m.method == http.MethodPost {
-		return fmt.Sprintf("[%s]&nbsp;&nbsp;&nbsp;%s", m.method, m.pattern)
-	}
-	if m.method == http.MethodPut {
-		return fmt.Sprintf("[%s]&nbsp;&nbsp;&nbsp;&nbsp;%s", m.method, m.pattern)
-	}
-	if m.method == http.MethodDelete {
-		return fmt.Sprintf("[%s]&nbsp;%s", m.method, m.pattern)
+// methods returns the methods registered for this entry in sorted order
+func (m muxEntry) methods() []string {
+	var ms []string
+	for method := range m.handlers {
+		ms = append(ms, method)
 	}
-	return fmt.Sprintf("[%s]&nbsp;%s", m.method, m.pattern)
+	sort.Strings(ms)
+	return ms
 }
 
-func (s *Muxer) Len() int {
-	return len(s.es)
-}
-
-func (s *Muxer) Less(i, j int) bool {
-	return s.es[i].pattern < s.es[j].pattern
+// allow returns the value of the Allow header for this entry
+func (m muxEntry) allow() string {
+	ms := m.methods()
+	if _, ok := m.handlers[http.MethodGet]; ok {
+		if _, ok := m.handlers[http.MethodHead]; !ok {
+			ms = append(ms, http.MethodHead)
+		}
+	}
+	if _, ok := m.handlers[http.MethodOptions]; !ok {
+		ms = append(ms, http.MethodOptions)
+	}
+	sort.Strings(ms)
+	return strings.Join(ms, ", ")
 }
 
-func (s *Muxer) Swap(i, j int) {
-	s.es[j], s.es[i] = s.es[i], s.es[j]
+// handler returns the handler registered for the supplied method. HEAD
+// requests fall back to the GET handler if there is no HEAD handler.
+func (m muxEntry) handler(method string) http.Handler {
+	if h, ok := m.handlers[method]; ok {
+		return h
+	}
+	if h, ok := m.handlers[http.MethodGet]; ok && method == http.MethodHead {
+		return h
+	}
+	return m.handlers[methodAny]
 }
 
-func (s *Muxer) Search(x string) int {
-	return sort.Search(len(s.es), func(i int) bool {
-		return s.es[i].pattern >= x
-	})
+func routeString(method, pattern string) string {
+	if method == http.MethodGet {
+		return fmt.Sprintf("[%s]&nbsp;&nbsp;&nbsp;&nbsp;<a href=\"%s\">%s</a>", method, pattern, pattern)
+	}
+	if method == http.MethodPost {
+		return fmt.Sprintf("[%s]&nbsp;&nbsp;&nbsp;%s", method, pattern)
+	}
+	if method == http.MethodPut {
+		return fmt.Sprintf("[%s]&nbsp;&nbsp;&nbsp;&nbsp;%s", method, pattern)
+	}
+	if method == http.MethodDelete {
+		return fmt.Sprintf("[%s]&nbsp;%s", method, pattern)
+	}
+	return fmt.Sprintf("[%s]&nbsp;%s", method, pattern)
 }
 
 type MuxerConfig struct {
-	StaticHandler http.Handler
-	ErrHandler    http.Handler
-	MetricsOn     bool
-	Logging       int
+	StaticHandler    http.Handler
+	ErrHandler       http.Handler
+	ErrRenderer      ErrorRenderer // optional, see Muxer.Error
+	NotFound         http.Handler  // optional, defaults to a 404 via Muxer.Error
+	MethodNotAllowed http.Handler  // optional, defaults to a 405 via Muxer.Error
+	MetricsOn        bool
+	Logging          int
 }
 
 var defaultMuxerConfig = &MuxerConfig{
@@ -75,12 +100,18 @@ func checkMuxerConfig(conf *MuxerConfig) {
 }
 
 type Muxer struct {
-	conf        *MuxerConfig
-	lock        sync.RWMutex
-	em          map[string]muxEntry
-	es          []muxEntry
-	logger      *Logger
-	withLogging bool
+	conf   *MuxerConfig
+	lock   sync.RWMutex
+	em     map[string]*muxEntry
+	tree   *node
+	nf     map[string]http.Handler
+	mounts map[string]*Muxer
+	names  map[string]string
+
+	notFoundHandler         http.Handler
+	methodNotAllowedHandler http.Handler
+	logger                  *Logger
+	withLogging             bool
 }
 
 // cleanPath returns the canonical path for p, eliminating . and .. elements
@@ -108,19 +139,30 @@ func cleanPath(p string) string {
 func NewMuxer(conf *MuxerConfig) *Muxer {
 	checkMuxerConfig(conf)
 	mux := &Muxer{
-		conf: conf,
-		em:   make(map[string]muxEntry),
-		es:   make([]muxEntry, 0),
+		conf:   conf,
+		em:     make(map[string]*muxEntry),
+		tree:   new(node),
+		nf:     make(map[string]http.Handler),
+		mounts: make(map[string]*Muxer),
+		names:  make(map[string]string),
 	}
 	if conf.Logging < LevelOff {
 		mux.logger = NewLogger(conf.Logging)
 		mux.withLogging = true
 	}
+	mux.notFoundHandler = conf.NotFound
+	if mux.notFoundHandler == nil {
+		mux.notFoundHandler = mux.errorHandler(http.StatusNotFound)
+	}
+	mux.methodNotAllowedHandler = conf.MethodNotAllowed
+	if mux.methodNotAllowedHandler == nil {
+		mux.methodNotAllowedHandler = mux.errorHandler(http.StatusMethodNotAllowed)
+	}
 	if conf.StaticHandler != nil {
 		mux.Get("/static/", conf.StaticHandler)
 	}
 	if conf.ErrHandler != nil {
-		mux.Get("/error/", conf.ErrHandler)
+		mux.Get("/error/*code", conf.ErrHandler).Name("error")
 	}
 	if conf.MetricsOn {
 		mux.Get("/metrics", mux.info())
@@ -128,7 +170,15 @@ func NewMuxer(conf *MuxerConfig) *Muxer {
 	return mux
 }
 
-func (s *Muxer) Handle(method string, pattern string, handler http.Handler) {
+// Handle registers the handler for the given method and pattern. Patterns
+// may contain named segments (":slug"), typed named segments (":id|int")
+// and a trailing catch-all ("*filepath"), all of which are made available
+// to the handler through Param. Patterns ending in "/" match any request
+// path they prefix, with the exception of the root pattern "/" which only
+// matches the root path. Each pattern may be registered once per method.
+// Routes should be registered before the muxer starts serving requests.
+// The returned route may be given a name, see Route.Name and Muxer.URL.
+func (s *Muxer) Handle(method string, pattern string, handler http.Handler) *Route {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
@@ -138,65 +188,55 @@ func (s *Muxer) Handle(method string, pattern string, handler http.Handler) {
 	if handler == nil {
 		panic("http: nil handler")
 	}
-	if _, exist := s.em[pattern]; exist {
-		panic("http: multiple registrations for " + pattern)
+	if entry, exist := s.em[pattern]; exist {
+		if _, exist := entry.handlers[method]; exist {
+			panic("http: multiple registrations for " + method + " " + pattern)
+		}
+		entry.handlers[method] = handler
+		return &Route{mux: s, method: method, pattern: pattern}
 	}
-	entry := muxEntry{
-		method:  method,
-		pattern: pattern,
-		handler: handler,
+	entry := &muxEntry{
+		pattern:  pattern,
+		handlers: map[string]http.Handler{method: handler},
+		segs:     parsePattern(pattern),
 	}
 	s.em[pattern] = entry
-	if pattern[len(pattern)-1] == '/' {
-		s.es = appendSorted(s.es, entry)
-	}
-}
-
-func appendSorted(es []muxEntry, e muxEntry) []muxEntry {
-	n := len(es)
-	i := sort.Search(n, func(i int) bool {
-		return len(es[i].pattern) < len(e.pattern)
-	})
-	if i == n {
-		return append(es, e)
+	if entry.segs != nil {
+		s.tree.insert(entry)
 	}
-	// we now know that i points at where we want to insert
-	es = append(es, muxEntry{}) // try to grow the slice in place, any entry works.
-	copy(es[i+1:], es[i:])      // Move shorter entries down
-	es[i] = e
-	return es
+	return &Route{mux: s, method: method, pattern: pattern}
 }
 
-func (s *Muxer) HandleFunc(method, pattern string, handler func(http.ResponseWriter, *http.Request)) {
+func (s *Muxer) HandleFunc(method, pattern string, handler func(http.ResponseWriter, *http.Request)) *Route {
 	if handler == nil {
 		panic("http: nil handler")
 	}
-	s.Handle(method, pattern, http.HandlerFunc(handler))
+	return s.Handle(method, pattern, http.HandlerFunc(handler))
 }
 
-func (s *Muxer) Forward(oldpattern string, newpattern string) {
-	s.Handle(http.MethodGet, oldpattern, http.RedirectHandler(newpattern, http.StatusTemporaryRedirect))
+func (s *Muxer) Forward(oldpattern string, newpattern string) *Route {
+	return s.Handle(http.MethodGet, oldpattern, http.RedirectHandler(newpattern, http.StatusTemporaryRedirect))
 }
 
-func (s *Muxer) Get(pattern string, handler http.Handler) {
-	s.Handle(http.MethodGet, pattern, handler)
+func (s *Muxer) Get(pattern string, handler http.Handler) *Route {
+	return s.Handle(http.MethodGet, pattern, handler)
 }
 
-func (s *Muxer) Post(pattern string, handler http.Handler) {
-	s.Handle(http.MethodPost, pattern, handler)
+func (s *Muxer) Post(pattern string, handler http.Handler) *Route {
+	return s.Handle(http.MethodPost, pattern, handler)
 }
 
-func (s *Muxer) Put(pattern string, handler http.Handler) {
-	s.Handle(http.MethodPut, pattern, handler)
+func (s *Muxer) Put(pattern string, handler http.Handler) *Route {
+	return s.Handle(http.MethodPut, pattern, handler)
 }
 
-func (s *Muxer) Delete(pattern string, handler http.Handler) {
-	s.Handle(http.MethodDelete, pattern, handler)
+func (s *Muxer) Delete(pattern string, handler http.Handler) *Route {
+	return s.Handle(http.MethodDelete, pattern, handler)
 }
 
-func (s *Muxer) Static(pattern string, path string) {
+func (s *Muxer) Static(pattern string, path string) *Route {
 	staticHandler := http.StripPrefix(pattern, http.FileServer(http.Dir(path)))
-	s.Handle(http.MethodGet, pattern, staticHandler)
+	return s.Handle(http.MethodGet, pattern, staticHandler)
 }
 
 func (s *Muxer) GetEntries() []string {
@@ -204,32 +244,72 @@ func (s *Muxer) GetEntries() []string {
 }
 
 func (s *Muxer) getEntries() []string {
-	s.lock.Lock()
-	defer s.lock.Unlock()
 	var entries []string
+	s.routes("", func(method, pattern string) {
+		entries = append(entries, fmt.Sprintf("%s %s\n", method, pattern))
+	})
+	return entries
+}
+
+// routes calls fn for every method and pattern registered on the
+// muxer, including those of any mounted muxers, using full paths
+func (s *Muxer) routes(prefix string, fn func(method, pattern string)) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
 	for _, entry := range s.em {
-		entries = append(entries, fmt.Sprintf("%s %s\n", entry.method, entry.pattern))
+		for _, method := range entry.methods() {
+			if method == methodAny {
+				continue
+			}
+			fn(method, prefix+entry.pattern)
+		}
 	}
-	return entries
+	for p, sub := range s.mounts {
+		sub.routes(prefix+p, fn)
+	}
+}
+
+// notFound returns the not found handler for the supplied path. Groups
+// may register their own, in which case the longest matching prefix wins.
+func (s *Muxer) notFound(path string) http.Handler {
+	var h http.Handler
+	var n = -1
+	for prefix, nf := range s.nf {
+		if len(prefix) <= n {
+			continue
+		}
+		if path == prefix || strings.HasPrefix(path, prefix+pathSeperator) || prefix == "" {
+			h, n = nf, len(prefix)
+		}
+	}
+	if h == nil {
+		return s.notFoundHandler
+	}
+	return h
 }
 
-// match attempts to locate a handler on a handler map given a
-// path string; most-specific (longest) pattern wins
-func (s *Muxer) match(path string) (string, string, http.Handler) {
+// match attempts to locate an entry given a path string. Static
+// patterns are found with a single map lookup, anything else is
+// found by walking the radix tree where the most-specific pattern
+// wins, along with any path parameters captured along the way
+func (s *Muxer) match(path string) (*muxEntry, map[string]string) {
 	// first, check for exact match
 	e, ok := s.em[path]
-	if ok {
-		return e.method, e.pattern, e.handler
-	}
-	// then, check for longest valid match. mux.es
-	// contains all patterns that end in "/" sorted
-	// from longest to shortest
-	for _, e = range s.es {
-		if strings.HasPrefix(path, e.pattern) {
-			return e.method, e.pattern, e.handler
-		}
+	if ok && e.segs == nil {
+		return e, nil
 	}
-	return "", "", nil
+	// then, check the tree for the most specific valid match
+	var buf [8]pathParam
+	ps := buf[:0]
+	e = s.tree.lookup(path, &ps)
+	if e == nil || len(ps) == 0 {
+		return e, nil
+	}
+	params := make(map[string]string, len(ps))
+	for _, p := range ps {
+		params[p.key] = p.value
+	}
+	return e, params
 }
 
 func (s *Muxer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -237,18 +317,29 @@ func (s *Muxer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		if r.ProtoAtLeast(1, 1) {
 			w.Header().Set("Connection", "close")
 		}
-		w.WriteHeader(http.StatusBadRequest)
+		s.Error(w, r, http.StatusBadRequest)
 		return
 	}
-	m, _, h := s.match(r.URL.Path)
-	if m != r.Method {
+	var h http.Handler
+	e, params := s.match(r.URL.Path)
+	if e != nil {
+		h = e.handler(r.Method)
+	}
+	switch {
+	case e == nil:
+		h = s.notFound(r.URL.Path)
+	case h != nil:
+		r = withParams(r, params)
+	case r.Method == http.MethodOptions:
 		h = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			code := http.StatusMethodNotAllowed
-			http.Error(w, http.StatusText(code), code)
+			w.Header().Set("Allow", e.allow())
+			w.WriteHeader(http.StatusNoContent)
+		})
+	default:
+		h = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Allow", e.allow())
+			s.methodNotAllowedHandler.ServeHTTP(w, r)
 		})
-	}
-	if h == nil {
-		h = http.NotFoundHandler()
 	}
 	if s.withLogging {
 		// if logging is configured, then log, otherwise skip
@@ -260,18 +351,17 @@ func (s *Muxer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 func (s *Muxer) info() http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		var data []string
-		data = append(data, fmt.Sprintf("<h3>Registered Routes (%d)</h3>", len(s.em)))
-		for _, entry := range s.em {
-			data = append(data, entry.String())
-		}
+		s.routes("", func(method, pattern string) {
+			data = append(data, routeString(method, pattern))
+		})
 		sort.Slice(data, func(i, j int) bool {
 			return data[i] < data[j]
 		})
+		data = append([]string{fmt.Sprintf("<h3>Registered Routes (%d)</h3>", len(data))}, data...)
 		s.ContentType(w, ".html")
 		_, err := fmt.Fprintf(w, strings.Join(data, "<br>"))
 		if err != nil {
-			code := http.StatusInternalServerError
-			http.Error(w, http.StatusText(code), code)
+			s.Error(w, r, http.StatusInternalServerError)
 			return
 		}
 		return
@@ -279,9 +369,12 @@ func (s *Muxer) info() http.Handler {
 	return http.HandlerFunc(fn)
 }
 
+// Logger returns the muxer's logger, or nil if logging is off
+func (s *Muxer) Logger() *Logger {
+	return s.logger
+}
+
 func (s *Muxer) ContentType(w http.ResponseWriter, content string) {
-	s.lock.Lock()
-	defer s.lock.Unlock()
 	ct := mime.TypeByExtension(content)
 	if ct == "" && s.withLogging {
 		s.logger.Error("Error, incompatible content type!\n")
@@ -320,7 +413,7 @@ func (s *Muxer) requestLogger(next http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if err := recover(); err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
+				s.Error(w, r, http.StatusInternalServerError)
 				s.logger.Error("err: %v, trace: %s\n", err, debug.Stack())
 			}
 		}()
@@ -374,15 +467,12 @@ func DefaultMuxerErrorHandler() http.Handler {
 				http.Error(w, http.StatusText(code), code)
 				return
 			}
-			err = defaultErrTmpl.Execute(w, struct {
-				ErrorCode     int
-				ErrorText     string
-				ErrorTextLong string
-			}{
-				ErrorCode:     code,
-				ErrorText:     http.StatusText(code),
-				ErrorTextLong: HTTPCodesLongFormat[code],
-			})
+			if wantsJSON(r) {
+				_ = WriteProblem(w, NewProblem(r, code))
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			err = defaultErrTmpl.Execute(w, errTmplData(code))
 			if err != nil {
 				code := http.StatusExpectationFailed
 				http.Error(w, http.StatusText(code), code)
@@ -0,0 +1,168 @@
+package webapp
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// paramsKey is the request context key that the muxer
+// stores any captured path parameters under
+type paramsKey struct{}
+
+// Param returns the value of the named path parameter captured by
+// the muxer for the current request, or an empty string if there
+// is no parameter by that name. For the pattern "/user/:id" and the
+// request path "/user/42", Param(r, "id") returns "42"
+func Param(r *http.Request, name string) string {
+	return Params(r)[name]
+}
+
+// Params returns all the path parameters captured by the muxer for
+// the current request. It returns nil if the route had no parameters
+func Params(r *http.Request) map[string]string {
+	params, _ := r.Context().Value(paramsKey{}).(map[string]string)
+	return params
+}
+
+// withParams returns a shallow copy of r carrying the supplied params
+func withParams(r *http.Request, params map[string]string) *http.Request {
+	if len(params) == 0 {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), paramsKey{}, params))
+}
+
+type segmentKind int
+
+const (
+	segStatic   segmentKind = iota // literal path segment, eg. "widgets"
+	segParam                       // named segment, eg. ":slug" or ":id|int"
+	segWildcard                    // trailing catch-all, eg. "*filepath" or a trailing "/"
+)
+
+// segment is a single parsed piece of a route pattern
+type segment struct {
+	kind  segmentKind
+	value string            // literal text, or the parameter name
+	typ   string            // parameter type name, if typed
+	check func(string) bool // parameter type check, if typed
+}
+
+// ParamTypes holds the checks available to typed path parameters.
+// A typed parameter is written as ":name|type", for example the
+// pattern "/api/widgets/:slug/parts/:id|int" will only match when
+// the id segment is made up entirely of digits. Additional types
+// may be added before any routes using them are registered.
+var ParamTypes = map[string]func(string) bool{
+	"int":   isDigits,
+	"alpha": isAlphas,
+	"alnum": isAlnums,
+	"hex":   isHexes,
+}
+
+// parsePattern breaks a route pattern into its segments. A pattern
+// ending in "/" (other than the root pattern) is treated as a prefix
+// and gets an unnamed trailing wildcard. It returns nil for patterns
+// that only contain static segments.
+func parsePattern(pattern string) []segment {
+	if pattern == "/" {
+		return nil
+	}
+	p := strings.TrimPrefix(pattern, pathSeperator)
+	prefix := strings.HasSuffix(p, pathSeperator)
+	if prefix {
+		p = strings.TrimSuffix(p, pathSeperator)
+	}
+	var segs []segment
+	var dynamic bool
+	parts := strings.Split(p, pathSeperator)
+	for i, part := range parts {
+		switch {
+		case part == "":
+			panic("http: empty segment in pattern " + pattern)
+		case part[0] == ':':
+			seg := segment{kind: segParam, value: part[1:]}
+			if n := strings.IndexByte(seg.value, '|'); n > -1 {
+				seg.value, seg.typ = seg.value[:n], seg.value[n+1:]
+				seg.check = ParamTypes[seg.typ]
+				if seg.check == nil {
+					panic("http: unknown parameter type " + seg.typ + " in pattern " + pattern)
+				}
+			}
+			if seg.value == "" {
+				panic("http: unnamed parameter in pattern " + pattern)
+			}
+			segs = append(segs, seg)
+			dynamic = true
+		case part[0] == '*':
+			if i != len(parts)-1 || prefix {
+				panic("http: wildcard must be the last segment in pattern " + pattern)
+			}
+			segs = append(segs, segment{kind: segWildcard, value: part[1:]})
+			dynamic = true
+		default:
+			segs = append(segs, segment{kind: segStatic, value: part})
+		}
+	}
+	if prefix {
+		segs = append(segs, segment{kind: segWildcard})
+		dynamic = true
+	}
+	if !dynamic {
+		return nil
+	}
+	return segs
+}
+
+// rank is used to order competing segments, lower is more specific
+func (seg segment) rank() int {
+	switch {
+	case seg.kind == segStatic:
+		return 0
+	case seg.kind == segParam && seg.check != nil:
+		return 1
+	case seg.kind == segParam:
+		return 2
+	default:
+		return 3
+	}
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isAlphas(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i] | 0x20
+		if c < 'a' || c > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+func isAlnums(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isDigits(s[i:i+1]) && !isAlphas(s[i:i+1]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isHexes(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i] | 0x20
+		if (c < 'a' || c > 'f') && (s[i] < '0' || s[i] > '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,273 @@
+package webapp
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrHashFormat is returned when an encoded password hash is not in
+// a format any of the password hashers understand
+var ErrHashFormat = errors.New("password: unknown hash format")
+
+// PasswordHasher hashes passwords for storage. Hashes are encoded in a
+// self-describing format holding the algorithm, its parameters and the
+// salt, so a hash can always be verified, whichever hasher made it, and
+// stored hashes can be upgraded as users log in (see CheckPassword.)
+type PasswordHasher interface {
+	// Hash returns the encoded hash of the password
+	Hash(password string) (string, error)
+
+	// Verify reports whether the password matches the encoded hash, which
+	// may have been made by any of the supported algorithms
+	Verify(password, encoded string) bool
+
+	// NeedsRehash reports whether the encoded hash was made by another
+	// algorithm, or with other parameters, than the hasher uses
+	NeedsRehash(encoded string) bool
+}
+
+// DefaultPasswordHasher is the password hasher used by HashPassword,
+// CheckPassword and the AuthUser implementations
+var DefaultPasswordHasher PasswordHasher = NewArgon2idHasher()
+
+// HashPassword hashes the password using the DefaultPasswordHasher
+func HashPassword(password string) (string, error) {
+	return DefaultPasswordHasher.Hash(password)
+}
+
+// CheckPassword reports whether the password matches the encoded hash,
+// and if it does, whether the hash should be replaced with a new hash of
+// the password, because the DefaultPasswordHasher has changed since
+func CheckPassword(password, encoded string) (ok, rehash bool) {
+	return checkPassword(DefaultPasswordHasher, password, encoded)
+}
+
+func checkPassword(h PasswordHasher, password, encoded string) (ok, rehash bool) {
+	if !h.Verify(password, encoded) {
+		return false, false
+	}
+	return true, h.NeedsRehash(encoded)
+}
+
+// Argon2idHasher hashes passwords with argon2id, encoded in the PHC
+// string format, eg. $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+type Argon2idHasher struct {
+	Time    uint32 // Time is the number of passes over the memory
+	Memory  uint32 // Memory is the memory used, in KiB
+	Threads uint8  // Threads is the degree of parallelism
+	SaltLen int    // SaltLen is the length of the random salt
+	KeyLen  uint32 // KeyLen is the length of the hash
+}
+
+// NewArgon2idHasher returns an argon2id hasher using the parameters
+// recommended by RFC 9106 for memory constrained environments
+func NewArgon2idHasher() *Argon2idHasher {
+	return &Argon2idHasher{
+		Time:    3,
+		Memory:  64 * 1024,
+		Threads: 2,
+		SaltLen: 16,
+		KeyLen:  32,
+	}
+}
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("password: reading random bytes: %w", err)
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Time, h.Memory, h.Threads, h.KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, h.Memory, h.Time, h.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h *Argon2idHasher) Verify(password, encoded string) bool {
+	return verifyPassword(password, encoded)
+}
+
+func (h *Argon2idHasher) NeedsRehash(encoded string) bool {
+	p, err := parseArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return p.Time != h.Time || p.Memory != h.Memory || p.Threads != h.Threads ||
+		len(p.salt) != h.SaltLen || len(p.key) != int(h.KeyLen)
+}
+
+// argon2idHash is a decoded argon2id hash
+type argon2idHash struct {
+	Argon2idHasher
+	salt []byte
+	key  []byte
+}
+
+func parseArgon2id(encoded string) (*argon2idHash, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return nil, ErrHashFormat
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, ErrHashFormat
+	}
+	h := new(argon2idHash)
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.Memory, &h.Time, &h.Threads)
+	if err != nil || h.Time == 0 || h.Threads == 0 {
+		return nil, ErrHashFormat
+	}
+	h.salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, ErrHashFormat
+	}
+	h.key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(h.key) == 0 {
+		return nil, ErrHashFormat
+	}
+	return h, nil
+}
+
+// BcryptHasher hashes passwords with bcrypt, which has its own
+// encoding, eg. $2a$12$<salt and hash>. Keep in mind that bcrypt
+// only uses the first 72 bytes of a password.
+type BcryptHasher struct {
+	Cost int // Cost is the bcrypt cost, between 4 and 31
+}
+
+// NewBcryptHasher returns a bcrypt hasher using the given cost, or
+// bcrypt.DefaultCost if it is zero
+func NewBcryptHasher(cost int) *BcryptHasher {
+	if cost == 0 {
+		cost = bcrypt.DefaultCost
+	}
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		panic(fmt.Sprintf("password: bcrypt cost %d out of range", cost))
+	}
+	return &BcryptHasher{Cost: cost}
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	b, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", fmt.Errorf("password: %w", err)
+	}
+	return string(b), nil
+}
+
+func (h *BcryptHasher) Verify(password, encoded string) bool {
+	return verifyPassword(password, encoded)
+}
+
+func (h *BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.Cost
+}
+
+// verifyPassword checks the password against a hash encoded by any of
+// the password hashers, comparing the hashes in constant time
+func verifyPassword(password, encoded string) bool {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		h, err := parseArgon2id(encoded)
+		if err != nil {
+			return false
+		}
+		key := argon2.IDKey([]byte(password), h.salt, h.Time, h.Memory, h.Threads, uint32(len(h.key)))
+		return subtle.ConstantTimeCompare(key, h.key) == 1
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		return bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password)) == nil
+	}
+	return false
+}
+
+var (
+	dummyHash     string
+	dummyHashOnce sync.Once
+)
+
+// wastePasswordCheck checks the password against a hash made by the
+// hasher, and is used when there is no such user, so that an attacker
+// can't tell if a username exists by how long a login takes
+func wastePasswordCheck(h PasswordHasher, password string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = h.Hash("dummy password")
+	})
+	h.Verify(password, dummyHash)
+}
+
+// PasswordPolicy holds the rules new passwords must follow
+type PasswordPolicy struct {
+	MinLength     int  // MinLength is the minimum number of characters
+	MaxLength     int  // MaxLength is the maximum number of characters, 0 for no limit
+	RequireUpper  bool // RequireUpper requires an upper case letter
+	RequireLower  bool // RequireLower requires a lower case letter
+	RequireDigit  bool // RequireDigit requires a digit
+	RequireSymbol bool // RequireSymbol requires something other than a letter or digit
+	NotUsername   bool // NotUsername rejects passwords containing the username
+}
+
+// DefaultPasswordPolicy follows NIST SP 800-63B, which asks for length
+// rather than a mix of character classes
+var DefaultPasswordPolicy = &PasswordPolicy{
+	MinLength:   8,
+	MaxLength:   128,
+	NotUsername: true,
+}
+
+// Check returns ValidationErrors for the "password" field, holding a
+// FieldError for every rule the password breaks, or nil if it is fine
+func (p *PasswordPolicy) Check(username, password string) error {
+	var errs ValidationErrors
+	fail := func(rule, msg string) {
+		errs = append(errs, FieldError{Field: "password", Rule: rule, Message: msg})
+	}
+	n := utf8.RuneCountInString(password)
+	if n < p.MinLength {
+		fail("min", "must be at least "+strconv.Itoa(p.MinLength)+" characters")
+	}
+	if p.MaxLength > 0 && n > p.MaxLength {
+		fail("max", "must be at most "+strconv.Itoa(p.MaxLength)+" characters")
+	}
+	var upper, lower, digit, symbol bool
+	for _, c := range password {
+		switch {
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsLower(c):
+			lower = true
+		case unicode.IsDigit(c):
+			digit = true
+		case !unicode.IsLetter(c):
+			symbol = true
+		}
+	}
+	if p.RequireUpper && !upper {
+		fail("upper", "must contain an upper case letter")
+	}
+	if p.RequireLower && !lower {
+		fail("lower", "must contain a lower case letter")
+	}
+	if p.RequireDigit && !digit {
+		fail("digit", "must contain a digit")
+	}
+	if p.RequireSymbol && !symbol {
+		fail("symbol", "must contain a symbol")
+	}
+	if p.NotUsername && username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		fail("username", "must not contain the username")
+	}
+	if errs != nil {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package webapp
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// testArgon2id is cheap, to keep the tests fast
+func testArgon2id() *Argon2idHasher {
+	return &Argon2idHasher{Time: 1, Memory: 1024, Threads: 1, SaltLen: 16, KeyLen: 32}
+}
+
+func TestPasswordHashers(t *testing.T) {
+	hashers := map[string]PasswordHasher{
+		"argon2id": testArgon2id(),
+		"bcrypt":   NewBcryptHasher(4),
+	}
+	for name, h := range hashers {
+		hash, err := h.Hash("correct horse")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if again, _ := h.Hash("correct horse"); again == hash {
+			t.Errorf("%s: hashes are not salted", name)
+		}
+		tests := []struct {
+			password string
+			encoded  string
+			want     bool
+		}{
+			{"correct horse", hash, true},
+			{"correct horsE", hash, false},
+			{"", hash, false},
+			{"correct horse", hash[:len(hash)-4], false},
+			{"correct horse", "", false},
+			{"correct horse", "correct horse", false},
+		}
+		for _, tt := range tests {
+			if got := h.Verify(tt.password, tt.encoded); got != tt.want {
+				t.Errorf("%s: Verify(%q, %q) = %v, want %v", name, tt.password, tt.encoded, got, tt.want)
+			}
+		}
+		if h.NeedsRehash(hash) {
+			t.Errorf("%s: its own hash needs a rehash", name)
+		}
+	}
+}
+
+func TestPasswordNeedsRehash(t *testing.T) {
+	argon := testArgon2id()
+	stronger := testArgon2id()
+	stronger.Time = 2
+	argonHash, _ := argon.Hash("pw")
+	bcryptHash, _ := NewBcryptHasher(4).Hash("pw")
+	tests := []struct {
+		name    string
+		h       PasswordHasher
+		encoded string
+		want    bool
+	}{
+		{"same argon2id parameters", argon, argonHash, false},
+		{"stronger argon2id parameters", stronger, argonHash, true},
+		{"bcrypt hash for argon2id", argon, bcryptHash, true},
+		{"same bcrypt cost", NewBcryptHasher(4), bcryptHash, false},
+		{"higher bcrypt cost", NewBcryptHasher(5), bcryptHash, true},
+		{"argon2id hash for bcrypt", NewBcryptHasher(4), argonHash, true},
+		{"garbage", argon, "$argon2id$v=19$garbage", true},
+	}
+	for _, tt := range tests {
+		if got := tt.h.NeedsRehash(tt.encoded); got != tt.want {
+			t.Errorf("%s: NeedsRehash = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseArgon2id(t *testing.T) {
+	tests := []string{
+		"",
+		"$argon2i$v=19$m=1024,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=16$m=1024,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=1024,t=0,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=1024,t=1,p=1$!!!$a2V5",
+		"$argon2id$v=19$m=1024,t=1,p=1$c2FsdA$",
+		"$argon2id$v=19$m=1024,t=1,p=1$c2FsdA",
+	}
+	for _, encoded := range tests {
+		if _, err := parseArgon2id(encoded); !errors.Is(err, ErrHashFormat) {
+			t.Errorf("parseArgon2id(%q) = %v, want ErrHashFormat", encoded, err)
+		}
+	}
+}
+
+func TestRehashOnLogin(t *testing.T) {
+	users := NewSystemSessionUser()
+	users.Hasher = NewBcryptHasher(4)
+	if err := users.Register("bob", "correct horse", RoleUser); err != nil {
+		t.Fatal(err)
+	}
+	stored := func() string {
+		v, _ := users.users.Load("bob")
+		return v.(*SystemUser).Password
+	}
+	// the hasher changes, the stored hash is upgraded at the next login
+	users.Hasher = testArgon2id()
+	if _, ok := users.Authenticate("bob", "wrong"); ok {
+		t.Fatal("wrong password accepted")
+	}
+	if !strings.HasPrefix(stored(), "$2a$") {
+		t.Fatal("hash replaced after a failed login")
+	}
+	user, ok := users.Authenticate("bob", "correct horse")
+	if !ok {
+		t.Fatal("bcrypt hash not accepted after the hasher changed")
+	}
+	if user.Password != "" {
+		t.Error("Authenticate returned the password hash")
+	}
+	if !strings.HasPrefix(stored(), "$argon2id$") {
+		t.Fatalf("hash not upgraded: %s", stored())
+	}
+	if _, ok := users.Authenticate("bob", "correct horse"); !ok {
+		t.Fatal("upgraded hash not accepted")
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	strict := &PasswordPolicy{MinLength: 8, MaxLength: 16, RequireUpper: true, RequireLower: true,
+		RequireDigit: true, RequireSymbol: true, NotUsername: true}
+	tests := []struct {
+		name     string
+		policy   *PasswordPolicy
+		password string
+		rules    []string
+	}{
+		{"long enough", DefaultPasswordPolicy, "correct horse", nil},
+		{"too short", DefaultPasswordPolicy, "short", []string{"min"}},
+		{"counts characters not bytes", DefaultPasswordPolicy, "ééééééé", []string{"min"}},
+		{"contains the username", DefaultPasswordPolicy, "xxBOBxxxx", []string{"username"}},
+		{"strict and fine", strict, "Abcdef1!", nil},
+		{"strict and too long", strict, "Abcdef1!Abcdef1!A", []string{"max"}},
+		{"strict and plain", strict, "abcdefgh", []string{"upper", "digit", "symbol"}},
+	}
+	for _, tt := range tests {
+		err := tt.policy.Check("bob", tt.password)
+		var verrs ValidationErrors
+		errors.As(err, &verrs)
+		var rules []string
+		for _, e := range verrs {
+			rules = append(rules, e.Rule)
+		}
+		if strings.Join(rules, ",") != strings.Join(tt.rules, ",") {
+			t.Errorf("%s: broken rules %v, want %v", tt.name, rules, tt.rules)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package webapp
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// UserLookup is implemented by AuthUsers that can look a user up by
+// name, which logging in with a "remember me" token requires
+type UserLookup interface {
+	// User returns the user, without the password hash, or ErrUserNotFound
+	User(username string) (*SystemUser, error)
+}
+
+// rememberToken is the stored form of a "remember me" token
+type rememberToken struct {
+	Username string `json:"username"`
+	Hash     []byte `json:"hash"` // Hash is the SHA-256 of the validator
+}
+
+// rememberer issues and checks "remember me" tokens. The cookie holds a
+// selector, which is the key the token is stored under, and a validator,
+// of which only a hash is stored, so the store can't be used to make
+// cookies. Tokens are used once, and replaced by a new token each time.
+type rememberer struct {
+	kv     SessionKV
+	users  UserLookup
+	name   string
+	maxAge time.Duration
+	conf   *SessionConfig // conf has the cookie attributes
+	logger *Logger
+}
+
+// issue stores a new token for the user and sets the cookie
+func (rm *rememberer) issue(w http.ResponseWriter, username string) {
+	selector, validator := make([]byte, 16), make([]byte, 32)
+	if _, err := rand.Read(selector); err != nil {
+		rm.logError("generating token", err)
+		return
+	}
+	if _, err := rand.Read(validator); err != nil {
+		rm.logError("generating token", err)
+		return
+	}
+	hash := sha256.Sum256(validator)
+	b, err := json.Marshal(rememberToken{Username: username, Hash: hash[:]})
+	if err != nil {
+		rm.logError("encoding token", err)
+		return
+	}
+	key := base64.RawURLEncoding.EncodeToString(selector)
+	expires := time.Now().Add(rm.maxAge)
+	if err := rm.kv.Set(key, b, expires); err != nil {
+		rm.logError("storing token", err)
+		return
+	}
+	http.SetCookie(w, rm.conf.newCookie(rm.name, key+"."+base64.RawURLEncoding.EncodeToString(validator), expires))
+}
+
+// login checks the token in the request cookie, and returns the user it
+// was issued to. The token is removed, a new one should be issued.
+func (rm *rememberer) login(w http.ResponseWriter, r *http.Request) (*SystemUser, bool) {
+	key, validator, ok := rm.read(r)
+	if !ok {
+		return nil, false
+	}
+	b, err := rm.kv.Get(key)
+	if err != nil {
+		// expired, or already used, so forget the cookie
+		http.SetCookie(w, rm.conf.newCookie(rm.name, "", time.Now()))
+		return nil, false
+	}
+	_ = rm.kv.Delete(key)
+	var tok rememberToken
+	if err := json.Unmarshal(b, &tok); err != nil {
+		rm.logError("decoding token", err)
+		return nil, false
+	}
+	hash := sha256.Sum256(validator)
+	if subtle.ConstantTimeCompare(hash[:], tok.Hash) != 1 {
+		// a known selector with the wrong validator could be a stolen
+		// token, which is why the token has been removed regardless
+		http.SetCookie(w, rm.conf.newCookie(rm.name, "", time.Now()))
+		return nil, false
+	}
+	user, err := rm.users.User(tok.Username)
+	if err != nil || !user.IsActive {
+		http.SetCookie(w, rm.conf.newCookie(rm.name, "", time.Now()))
+		return nil, false
+	}
+	return user, true
+}
+
+// forget removes the token of the request, and its cookie
+func (rm *rememberer) forget(w http.ResponseWriter, r *http.Request) {
+	if getCookie(r, rm.name) == nil {
+		return
+	}
+	if key, _, ok := rm.read(r); ok {
+		if err := rm.kv.Delete(key); err != nil {
+			rm.logError("deleting token", err)
+		}
+	}
+	http.SetCookie(w, rm.conf.newCookie(rm.name, "", time.Now()))
+}
+
+// read returns the selector and validator from the request cookie
+func (rm *rememberer) read(r *http.Request) (string, []byte, bool) {
+	c := getCookie(r, rm.name)
+	if c == nil {
+		return "", nil, false
+	}
+	i := strings.IndexByte(c.Value, '.')
+	if i < 0 {
+		return "", nil, false
+	}
+	key := c.Value[:i]
+	validator, err := base64.RawURLEncoding.DecodeString(c.Value[i+1:])
+	if err != nil || len(validator) != 32 {
+		return "", nil, false
+	}
+	return key, validator, true
+}
+
+func (rm *rememberer) logError(what string, err error) {
+	if rm.logger != nil {
+		rm.logger.Error("auth: remember me: %s: %s\n", what, err)
+	}
+}
+
+// gc removes expired tokens from stores that can do so, every hour
+func (rm *rememberer) gc() {
+	if e, ok := rm.kv.(SessionExpirer); ok {
+		if err := e.DeleteExpired(); err != nil {
+			rm.logError("deleting expired tokens", err)
+		}
+	}
+	time.AfterFunc(time.Hour, func() { rm.gc() })
+}
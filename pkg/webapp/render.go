@@ -0,0 +1,215 @@
+package webapp
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	MimeJSON = "application/json"
+	MimeXML  = "application/xml"
+	MimeHTML = "text/html"
+	MimeText = "text/plain"
+	MimeCSV  = "text/csv"
+)
+
+// ErrNotAcceptable is returned by Renderer.Render when none of the
+// formats available for a response are acceptable to the client
+var ErrNotAcceptable = errors.New("render: no acceptable content type")
+
+// Renderer writes responses in a number of formats. Any encoding or
+// template errors are reported to the logger (if there is one) and
+// result in a 500 response, rather than being silently dropped.
+type Renderer struct {
+	tmpl   *TemplateCache
+	logger *Logger
+	errs   ErrorRenderer
+}
+
+// NewRenderer returns a new renderer. The template cache is used to
+// render HTML responses, the logger to report errors and the error
+// renderer for error responses (such as a 406 from Render.) Any of
+// them may be nil, in which case the renderer does without.
+func NewRenderer(tc *TemplateCache, logger *Logger, errs ErrorRenderer) *Renderer {
+	if errs == nil {
+		errs = DefaultErrorRenderer
+	}
+	return &Renderer{
+		tmpl:   tc,
+		logger: logger,
+		errs:   errs,
+	}
+}
+
+// JSON writes v as JSON with the supplied status code
+func (rd *Renderer) JSON(w http.ResponseWriter, code int, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return rd.fail(w, "json", err)
+	}
+	return rd.write(w, code, MimeJSON+"; charset=utf-8", append(b, '\n'))
+}
+
+// XML writes v as XML with the supplied status code
+func (rd *Renderer) XML(w http.ResponseWriter, code int, v interface{}) error {
+	b, err := xml.Marshal(v)
+	if err != nil {
+		return rd.fail(w, "xml", err)
+	}
+	return rd.write(w, code, MimeXML+"; charset=utf-8", append([]byte(xml.Header), b...))
+}
+
+// HTML executes the named template from the template cache with the
+// supplied data, and writes it with the supplied status code
+func (rd *Renderer) HTML(w http.ResponseWriter, code int, name string, data interface{}) error {
+	if rd.tmpl == nil {
+		return rd.fail(w, "html", errors.New("no template cache"))
+	}
+	buf := new(bytes.Buffer)
+	err := rd.tmpl.t.ExecuteTemplate(buf, name, data)
+	if err != nil {
+		return rd.fail(w, "html", err)
+	}
+	return rd.write(w, code, MimeHTML+"; charset=utf-8", buf.Bytes())
+}
+
+// Text writes a formatted plain text response with the supplied status code
+func (rd *Renderer) Text(w http.ResponseWriter, code int, format string, a ...interface{}) error {
+	s := format
+	if len(a) > 0 {
+		s = fmt.Sprintf(format, a...)
+	}
+	return rd.write(w, code, MimeText+"; charset=utf-8", []byte(s))
+}
+
+// CSV writes the records as CSV with the supplied status code
+func (rd *Renderer) CSV(w http.ResponseWriter, code int, records [][]string) error {
+	buf := new(bytes.Buffer)
+	cw := csv.NewWriter(buf)
+	err := cw.WriteAll(records)
+	if err != nil {
+		return rd.fail(w, "csv", err)
+	}
+	return rd.write(w, code, MimeCSV+"; charset=utf-8", buf.Bytes())
+}
+
+// Render writes v in the format best matching the Accept header of the
+// request. JSON and plain text are always available. XML is available
+// when v can be encoded as XML (maps, for one, can't be), HTML when a
+// template name is supplied, and CSV when v is a [][]string. JSON is
+// used when the client has no preference, and if none of the formats
+// are acceptable a 406 is written and ErrNotAcceptable returned.
+func (rd *Renderer) Render(w http.ResponseWriter, r *http.Request, code int, v interface{}, name string) error {
+	offers := []string{MimeJSON}
+	// only encode v as XML for clients that might want it
+	var xmlBody []byte
+	if strings.Contains(r.Header.Get("Accept"), "xml") {
+		if b, err := xml.Marshal(v); err == nil {
+			xmlBody = append([]byte(xml.Header), b...)
+			offers = append(offers, MimeXML)
+		}
+	}
+	if name != "" {
+		offers = append(offers, MimeHTML)
+	}
+	records, isCSV := v.([][]string)
+	if isCSV {
+		offers = append(offers, MimeCSV)
+	}
+	offers = append(offers, MimeText)
+	// the body depends on the Accept header, so caches must key on it
+	w.Header().Add("Vary", "Accept")
+	switch Negotiate(r, offers...) {
+	case MimeJSON:
+		return rd.JSON(w, code, v)
+	case MimeXML:
+		return rd.write(w, code, MimeXML+"; charset=utf-8", xmlBody)
+	case MimeHTML:
+		return rd.HTML(w, code, name, v)
+	case MimeCSV:
+		return rd.CSV(w, code, records)
+	case MimeText:
+		return rd.Text(w, code, "%v", v)
+	}
+	rd.errs(w, r, http.StatusNotAcceptable)
+	return ErrNotAcceptable
+}
+
+// write writes the response body with the content type and status code
+func (rd *Renderer) write(w http.ResponseWriter, code int, contentType string, b []byte) error {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(code)
+	_, err := w.Write(b)
+	if err != nil && rd.logger != nil {
+		rd.logger.Error("render: writing response: %s\n", err)
+	}
+	return err
+}
+
+// fail logs an encoding error and writes a 500 response
+func (rd *Renderer) fail(w http.ResponseWriter, format string, err error) error {
+	err = fmt.Errorf("render: %s encoding failed: %w", format, err)
+	if rd.logger != nil {
+		rd.logger.Error("%s\n", err)
+	}
+	code := http.StatusInternalServerError
+	http.Error(w, http.StatusText(code), code)
+	return err
+}
+
+// Negotiate returns the offered media type best matching the Accept
+// header of the request, preferring earlier offers on a tie. If the
+// request has no Accept header the first offer is returned, and if
+// none of the offers are acceptable it returns an empty string.
+func Negotiate(r *http.Request, offers ...string) string {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		if len(offers) > 0 {
+			return offers[0]
+		}
+		return ""
+	}
+	type mediaRange struct {
+		typ, sub string
+		q        float64
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		mt, q := parseMediaRange(part)
+		typ, sub := mt, ""
+		if i := strings.IndexByte(mt, '/'); i > -1 {
+			typ, sub = mt[:i], mt[i+1:]
+		}
+		ranges = append(ranges, mediaRange{typ: typ, sub: sub, q: q})
+	}
+	var best string
+	var bestQ float64
+	for _, offer := range offers {
+		typ, sub := offer, ""
+		if i := strings.IndexByte(offer, '/'); i > -1 {
+			typ, sub = offer[:i], offer[i+1:]
+		}
+		// the most specific matching range decides the quality
+		q, spec := 0.0, -1
+		for _, mr := range ranges {
+			switch {
+			case mr.typ == typ && mr.sub == sub && spec < 2:
+				q, spec = mr.q, 2
+			case mr.typ == typ && mr.sub == "*" && spec < 1:
+				q, spec = mr.q, 1
+			case mr.typ == "*" && mr.sub == "*" && spec < 0:
+				q, spec = mr.q, 0
+			}
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
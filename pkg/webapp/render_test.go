@@ -0,0 +1,47 @@
+package webapp
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRenderNegotiation(t *testing.T) {
+	type point struct {
+		X int `xml:"x" json:"x"`
+	}
+	rd := NewRenderer(nil, nil, nil)
+	tests := []struct {
+		name   string
+		accept string
+		v      interface{}
+		code   int
+		ctype  string
+	}{
+		{"no preference", "", point{1}, 200, MimeJSON},
+		{"xml", "application/xml", point{1}, 200, MimeXML},
+		{"xml preferred for a map", "application/xml, application/json;q=0.5", map[string]interface{}{"x": 1}, 200, MimeJSON},
+		{"only xml for a map", "application/xml", map[string]interface{}{"x": 1}, 406, ""},
+		{"xml or anything for a map", "application/xml, */*;q=0.1", map[string]interface{}{"x": 1}, 200, MimeJSON},
+		{"csv", "text/csv", [][]string{{"a", "b"}}, 200, MimeCSV},
+		{"text", "text/plain", point{1}, 200, MimeText},
+		{"nothing acceptable", "image/png", point{1}, 406, ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		w := httptest.NewRecorder()
+		rd.Render(w, r, 200, tt.v, "")
+		if w.Code != tt.code {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.code)
+		}
+		if w.Header().Get("Vary") != "Accept" {
+			t.Errorf("%s: Vary %q, want Accept", tt.name, w.Header().Get("Vary"))
+		}
+		if tt.ctype != "" && !strings.HasPrefix(w.Header().Get("Content-Type"), tt.ctype) {
+			t.Errorf("%s: content type %q, want %s", tt.name, w.Header().Get("Content-Type"), tt.ctype)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package webapp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+var (
+	ErrCookieInvalid = errors.New("cookie: invalid or tampered value")
+	ErrCookieExpired = errors.New("cookie: value has expired")
+)
+
+// minKeyLen is the minimum length of the keys used to sign cookies
+const minKeyLen = 16
+
+// cookieKey holds the signing and encryption keys derived from a
+// single configured key
+type cookieKey struct {
+	sign []byte
+	aead cipher.AEAD
+}
+
+// cookieCodec signs, and optionally encrypts, cookie values. The first
+// key is used for new values and every key is tried when decoding, so
+// keys can be rotated by adding a new key to the front of the list and
+// dropping the oldest one once the cookies it signed have expired.
+type cookieCodec struct {
+	keys    []cookieKey
+	encrypt bool
+}
+
+// newCookieCodec returns a codec using the supplied keys. If there are
+// no keys a random one is generated, so values will not survive a restart.
+func newCookieCodec(keys [][]byte, encrypt bool) *cookieCodec {
+	if len(keys) == 0 {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			panic("sessions: generating cookie key: " + err.Error())
+		}
+		keys = [][]byte{key}
+	}
+	c := &cookieCodec{encrypt: encrypt}
+	for _, key := range keys {
+		if len(key) < minKeyLen {
+			panic("sessions: cookie keys must be at least 16 bytes long")
+		}
+		block, err := aes.NewCipher(deriveKey(key, "encrypt"))
+		if err != nil {
+			panic("sessions: " + err.Error())
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			panic("sessions: " + err.Error())
+		}
+		c.keys = append(c.keys, cookieKey{sign: deriveKey(key, "sign"), aead: aead})
+	}
+	return c
+}
+
+// deriveKey derives a 256 bit key for a single purpose from the key
+func deriveKey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("webapp-cookie-" + purpose))
+	return mac.Sum(nil)
+}
+
+// encode returns the signed (and encrypted) value for the named cookie.
+// The time is included, so that decode can reject old values.
+func (c *cookieCodec) encode(name string, value []byte) (string, error) {
+	key := c.keys[0]
+	body := make([]byte, 8, 8+len(value))
+	binary.BigEndian.PutUint64(body, uint64(time.Now().Unix()))
+	body = append(body, value...)
+	if c.encrypt {
+		nonce := make([]byte, key.aead.NonceSize(), key.aead.NonceSize()+len(body)+key.aead.Overhead())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+		body = key.aead.Seal(nonce, nonce, body, []byte(name))
+	}
+	b := append(body, cookieMAC(key.sign, name, body)...)
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// decode verifies (and decrypts) a value made by encode for the named
+// cookie. Values older than maxAge are rejected, unless maxAge is zero.
+// It never panics, whatever the client sends.
+func (c *cookieCodec) decode(name, value string, maxAge time.Duration) ([]byte, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(b) < sha256.Size+8 {
+		return nil, ErrCookieInvalid
+	}
+	body, sum := b[:len(b)-sha256.Size], b[len(b)-sha256.Size:]
+	for _, key := range c.keys {
+		if !hmac.Equal(sum, cookieMAC(key.sign, name, body)) {
+			continue
+		}
+		if c.encrypt {
+			ns := key.aead.NonceSize()
+			if len(body) < ns {
+				return nil, ErrCookieInvalid
+			}
+			body, err = key.aead.Open(nil, body[:ns], body[ns:], []byte(name))
+			if err != nil || len(body) < 8 {
+				return nil, ErrCookieInvalid
+			}
+		}
+		created := time.Unix(int64(binary.BigEndian.Uint64(body)), 0)
+		if maxAge > 0 && time.Since(created) > maxAge {
+			return nil, ErrCookieExpired
+		}
+		return body[8:], nil
+	}
+	return nil, ErrCookieInvalid
+}
+
+// cookieMAC returns the HMAC of the cookie name and body
+func cookieMAC(key []byte, name string, body []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(name))
+	mac.Write([]byte{0})
+	mac.Write(body)
+	return mac.Sum(nil)
+}
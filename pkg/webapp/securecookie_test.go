@@ -0,0 +1,113 @@
+package webapp
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	testKey1 = []byte(strings.Repeat("1", 32))
+	testKey2 = []byte(strings.Repeat("2", 32))
+)
+
+// tamper flips a bit in the middle of an encoded value
+func tamper(v string) string {
+	b, _ := base64.RawURLEncoding.DecodeString(v)
+	b[len(b)/2] ^= 1
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func TestCookieCodec(t *testing.T) {
+	for _, encrypt := range []bool{false, true} {
+		c := newCookieCodec([][]byte{testKey1}, encrypt)
+		v, err := c.encode("sid", []byte("hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if encrypt && strings.Contains(string(mustDecodeB64(v)), "hello") {
+			t.Errorf("encrypted value holds the plain text")
+		}
+		tests := []struct {
+			name  string
+			value string
+			key   string
+			want  error
+		}{
+			{"round trip", v, "sid", nil},
+			{"tampered", tamper(v), "sid", ErrCookieInvalid},
+			{"other cookie name", v, "other", ErrCookieInvalid},
+			{"truncated", v[:10], "sid", ErrCookieInvalid},
+			{"not base64", "!!!", "sid", ErrCookieInvalid},
+			{"empty", "", "sid", ErrCookieInvalid},
+		}
+		for _, tt := range tests {
+			b, err := c.decode(tt.key, tt.value, time.Hour)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("encrypt=%v %s: err = %v, want %v", encrypt, tt.name, err, tt.want)
+				continue
+			}
+			if err == nil && string(b) != "hello" {
+				t.Errorf("encrypt=%v %s: got %q", encrypt, tt.name, b)
+			}
+		}
+	}
+}
+
+func TestCookieCodecExpiry(t *testing.T) {
+	c := newCookieCodec([][]byte{testKey1}, false)
+	// a value signed two hours ago
+	body := make([]byte, 8, 13)
+	binary.BigEndian.PutUint64(body, uint64(time.Now().Add(-2*time.Hour).Unix()))
+	body = append(body, "hello"...)
+	v := base64.RawURLEncoding.EncodeToString(append(body, cookieMAC(c.keys[0].sign, "sid", body)...))
+	tests := []struct {
+		maxAge time.Duration
+		want   error
+	}{
+		{time.Hour, ErrCookieExpired},
+		{3 * time.Hour, nil},
+		{0, nil},
+	}
+	for _, tt := range tests {
+		if _, err := c.decode("sid", v, tt.maxAge); !errors.Is(err, tt.want) {
+			t.Errorf("maxAge %s: err = %v, want %v", tt.maxAge, err, tt.want)
+		}
+	}
+}
+
+func TestCookieCodecKeyRotation(t *testing.T) {
+	for _, encrypt := range []bool{false, true} {
+		old := newCookieCodec([][]byte{testKey1}, encrypt)
+		rotated := newCookieCodec([][]byte{testKey2, testKey1}, encrypt)
+		dropped := newCookieCodec([][]byte{testKey2}, encrypt)
+		v, _ := old.encode("sid", []byte("hello"))
+		if b, err := rotated.decode("sid", v, 0); err != nil || string(b) != "hello" {
+			t.Errorf("encrypt=%v: value from the old key not accepted after rotation: %v", encrypt, err)
+		}
+		if _, err := dropped.decode("sid", v, 0); !errors.Is(err, ErrCookieInvalid) {
+			t.Errorf("encrypt=%v: value from a dropped key accepted", encrypt)
+		}
+		nv, _ := rotated.encode("sid", []byte("hello"))
+		if _, err := old.decode("sid", nv, 0); !errors.Is(err, ErrCookieInvalid) {
+			t.Errorf("encrypt=%v: new values not signed with the new key", encrypt)
+		}
+	}
+}
+
+func TestCookieCodecShortKey(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("short key accepted")
+		}
+	}()
+	newCookieCodec([][]byte{[]byte("short")}, false)
+}
+
+func mustDecodeB64(s string) []byte {
+	b, _ := base64.RawURLEncoding.DecodeString(s)
+	return b
+}
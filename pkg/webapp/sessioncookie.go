@@ -0,0 +1,180 @@
+package webapp
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// cookieChunkSize is the most value bytes put in a single cookie,
+	// leaving room for the name and attributes within the 4KB limit
+	cookieChunkSize = 3800
+
+	// maxCookieChunks limits the number of cookies a session may span
+	maxCookieChunks = 8
+)
+
+// ErrSessionTooLarge is logged when a session will not fit in the
+// cookies of a CookieSessionStore
+var ErrSessionTooLarge = errors.New("sessions: session too large for cookies")
+
+// CookieSessionStore implements the session manager interface by keeping
+// the whole session in the client's cookies, so there is no server side
+// state at all. Sessions are serialized (gob, unless the config has a
+// Serializer), encrypted with AES-GCM and signed, using the config Keys
+// (see SessionConfig), and the expiry is kept inside the encrypted value.
+//
+// Sessions too large for a single cookie are split across several, up to
+// about 30KB in all. Keep in mind that every request carries the cookies,
+// and that a session can't be revoked before it expires, as any copy of
+// the cookies remains valid until then.
+type CookieSessionStore struct {
+	*SessionConfig
+	codec *cookieCodec
+}
+
+// NewCookieSessionStore returns a new cookie session store. The Backend
+// of the config is not used.
+func NewCookieSessionStore(conf *SessionConfig) *CookieSessionStore {
+	conf = checkConfig(conf)
+	if conf.Serializer == nil {
+		conf.Serializer = GobSerializer{}
+	}
+	return &CookieSessionStore{
+		SessionConfig: conf,
+		codec:         newCookieCodec(conf.Keys, true),
+	}
+}
+
+// New creates and returns a new session
+func (cs *CookieSessionStore) New() *Session {
+	return newSession(cs.expiry(time.Now()))
+}
+
+// Get returns the session held in the cookies of the request, if
+// they are present, have not been tampered with and have not expired
+func (cs *CookieSessionStore) Get(r *http.Request) (*Session, bool) {
+	v, ok := cs.readChunks(r)
+	if !ok {
+		return nil, false
+	}
+	b, err := cs.codec.decode(cs.SessionID, v, cs.IdleTimeout)
+	if err != nil {
+		return nil, false
+	}
+	session := new(Session)
+	err = cs.Serializer.Unmarshal(b, session)
+	if err != nil {
+		cs.logError("decoding session", err)
+		return nil, false
+	}
+	if session.expired() {
+		return nil, false
+	}
+	return session, true
+}
+
+// Save writes the session to the response cookies. Passing a nil
+// session removes the session cookies.
+func (cs *CookieSessionStore) Save(w http.ResponseWriter, r *http.Request, session *Session) {
+	old := cs.chunkCount(r)
+	if session == nil {
+		cs.expireChunks(w, 0, old)
+		return
+	}
+	// the old cookies are simply replaced if the session was regenerated
+	session.renew(cs.SessionConfig)
+	if session.expired() {
+		// the absolute timeout has been reached
+		cs.expireChunks(w, 0, old)
+		return
+	}
+	expires := session.record().Expires
+	b, err := cs.Serializer.Marshal(session)
+	if err != nil {
+		cs.logError("encoding session", err)
+		return
+	}
+	v, err := cs.codec.encode(cs.SessionID, b)
+	if err != nil {
+		cs.logError("encoding session", err)
+		return
+	}
+	n := (len(v) + cookieChunkSize - 1) / cookieChunkSize
+	if n > maxCookieChunks {
+		cs.logError("saving session", ErrSessionTooLarge)
+		return
+	}
+	for i := 0; i < n; i++ {
+		end := (i + 1) * cookieChunkSize
+		if end > len(v) {
+			end = len(v)
+		}
+		chunk := v[i*cookieChunkSize : end]
+		if i == 0 {
+			// the first cookie holds the number of cookies
+			chunk = strconv.Itoa(n) + "." + chunk
+		}
+		http.SetCookie(w, cs.newCookie(cs.chunkName(i), chunk, expires))
+	}
+	cs.expireChunks(w, n, old)
+}
+
+// readChunks joins the values of the session cookies
+func (cs *CookieSessionStore) readChunks(r *http.Request) (string, bool) {
+	c := getCookie(r, cs.SessionID)
+	if c == nil {
+		return "", false
+	}
+	i := strings.IndexByte(c.Value, '.')
+	if i < 0 {
+		return "", false
+	}
+	n, err := strconv.Atoi(c.Value[:i])
+	if err != nil || n < 1 || n > maxCookieChunks {
+		return "", false
+	}
+	var sb strings.Builder
+	sb.WriteString(c.Value[i+1:])
+	for i := 1; i < n; i++ {
+		c := getCookie(r, cs.chunkName(i))
+		if c == nil {
+			return "", false
+		}
+		sb.WriteString(c.Value)
+	}
+	return sb.String(), true
+}
+
+// chunkCount returns the number of session cookies the request has
+func (cs *CookieSessionStore) chunkCount(r *http.Request) int {
+	n := 0
+	for n < maxCookieChunks && getCookie(r, cs.chunkName(n)) != nil {
+		n++
+	}
+	return n
+}
+
+// expireChunks removes the session cookies from..to-1
+func (cs *CookieSessionStore) expireChunks(w http.ResponseWriter, from, to int) {
+	for i := from; i < to; i++ {
+		http.SetCookie(w, cs.newCookie(cs.chunkName(i), "", time.Now()))
+	}
+}
+
+// chunkName returns the name of the nth session cookie
+func (cs *CookieSessionStore) chunkName(n int) string {
+	if n == 0 {
+		return cs.SessionID
+	}
+	return cs.SessionID + "_" + strconv.Itoa(n)
+}
+
+func (cs *CookieSessionStore) logError(what string, err error) {
+	if cs.Logger != nil {
+		cs.Logger.Error("sessions: %s: %s\n", what, err)
+	}
+}
@@ -0,0 +1,128 @@
+package webapp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileSessionKV is a SessionKV keeping each session in a file of its own
+// in a directory, so sessions survive restarts and can be shared by
+// processes on the same machine
+type FileSessionKV struct {
+	dir string
+}
+
+// NewFileSessionKV returns a store keeping sessions in dir,
+// creating the directory if it does not exist
+func NewFileSessionKV(dir string) (*FileSessionKV, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("sessions: creating directory: %w", err)
+	}
+	return &FileSessionKV{dir: dir}, nil
+}
+
+func (fs *FileSessionKV) Get(key string) ([]byte, error) {
+	p, err := fs.path(key)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < 8 {
+		return nil, ErrSessionNotFound
+	}
+	if time.Now().Unix() > int64(binary.BigEndian.Uint64(b)) {
+		_ = os.Remove(p)
+		return nil, ErrSessionNotFound
+	}
+	return b[8:], nil
+}
+
+// Set writes the value to a temporary file first, and renames it, so
+// readers never see a partly written session
+func (fs *FileSessionKV) Set(key string, value []byte, expires time.Time) error {
+	p, err := fs.path(key)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(fs.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	var hdr [8]byte
+	binary.BigEndian.PutUint64(hdr[:], uint64(expires.Unix()))
+	_, err = f.Write(append(hdr[:], value...))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), p)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
+
+func (fs *FileSessionKV) Delete(key string) error {
+	p, err := fs.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(p)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// DeleteExpired removes the files of any expired sessions
+func (fs *FileSessionKV) DeleteExpired() error {
+	names, err := filepath.Glob(filepath.Join(fs.dir, "sess-*"))
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	for _, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			continue
+		}
+		var hdr [8]byte
+		_, err = io.ReadFull(f, hdr[:])
+		f.Close()
+		if err != nil || now > int64(binary.BigEndian.Uint64(hdr[:])) {
+			_ = os.Remove(name)
+		}
+	}
+	return nil
+}
+
+// path returns the file path for a key. Keys are session ids, which are
+// base64 (url) encoded, anything else is rejected so a key can never
+// refer to a file outside of the directory.
+func (fs *FileSessionKV) path(key string) (string, error) {
+	if key == "" || len(key) > 128 {
+		return "", errInvalidKey
+	}
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return "", errInvalidKey
+		}
+	}
+	return filepath.Join(fs.dir, "sess-"+key), nil
+}
+
+var errInvalidKey = errors.New("sessions: invalid session key")
@@ -0,0 +1,159 @@
+package webapp
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrSessionNotFound is returned by a SessionKV when there is no
+// session stored under the key
+var ErrSessionNotFound = errors.New("sessions: session not found")
+
+// SessionKV is a key/value store that SessionStore can keep sessions
+// in, instead of in memory. Keys are session ids and values serialized
+// sessions. Stores may discard values once they expire, and if they
+// implement SessionExpirer the session store will ask them to.
+type SessionKV interface {
+	// Get returns the value stored under key, or ErrSessionNotFound
+	Get(key string) ([]byte, error)
+
+	// Set stores the value under key, replacing any existing value
+	Set(key string, value []byte, expires time.Time) error
+
+	// Delete removes the value stored under key, if there is one
+	Delete(key string) error
+}
+
+// MemorySessionKV is a SessionKV keeping values in memory, for use where
+// a SessionKV is needed but values don't have to survive a restart
+type MemorySessionKV struct {
+	values sync.Map
+}
+
+type memoryValue struct {
+	value   []byte
+	expires time.Time
+}
+
+// NewMemorySessionKV returns a new, empty, in memory store
+func NewMemorySessionKV() *MemorySessionKV {
+	return new(MemorySessionKV)
+}
+
+func (kv *MemorySessionKV) Get(key string) ([]byte, error) {
+	v, ok := kv.values.Load(key)
+	if !ok || time.Now().After(v.(memoryValue).expires) {
+		return nil, ErrSessionNotFound
+	}
+	return v.(memoryValue).value, nil
+}
+
+func (kv *MemorySessionKV) Set(key string, value []byte, expires time.Time) error {
+	kv.values.Store(key, memoryValue{value: append([]byte(nil), value...), expires: expires})
+	return nil
+}
+
+func (kv *MemorySessionKV) Delete(key string) error {
+	kv.values.Delete(key)
+	return nil
+}
+
+// DeleteExpired removes every expired value
+func (kv *MemorySessionKV) DeleteExpired() error {
+	now := time.Now()
+	kv.values.Range(func(k, v interface{}) bool {
+		if now.After(v.(memoryValue).expires) {
+			kv.values.Delete(k)
+		}
+		return true
+	})
+	return nil
+}
+
+// SessionExpirer is implemented by SessionKV stores that can
+// remove expired values themselves
+type SessionExpirer interface {
+	DeleteExpired() error
+}
+
+// SessionSerializer converts sessions to and from bytes for storage
+type SessionSerializer interface {
+	Marshal(s *Session) ([]byte, error)
+	Unmarshal(b []byte, s *Session) error
+}
+
+// sessionRecord is the stored form of a session
+type sessionRecord struct {
+	ID      string                 `json:"id"`
+	Data    map[string]interface{} `json:"data"`
+	Created time.Time              `json:"created"`
+	Expires time.Time              `json:"expires"`
+}
+
+// GobSerializer serializes sessions using encoding/gob. It is the default,
+// as it keeps the types of session values, but any types stored in the
+// session other than the basic types must be registered with
+// RegisterSessionType first.
+type GobSerializer struct{}
+
+func (GobSerializer) Marshal(s *Session) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	err := gob.NewEncoder(buf).Encode(s.record())
+	return buf.Bytes(), err
+}
+
+func (GobSerializer) Unmarshal(b []byte, s *Session) error {
+	var rec sessionRecord
+	err := gob.NewDecoder(bytes.NewReader(b)).Decode(&rec)
+	if err != nil {
+		return err
+	}
+	s.fromRecord(rec)
+	return nil
+}
+
+// JSONSerializer serializes sessions as JSON. The types of session values
+// are not kept, numbers come back as float64 and structs as maps, so it is
+// best suited to sessions holding strings, numbers and bools.
+type JSONSerializer struct{}
+
+func (JSONSerializer) Marshal(s *Session) ([]byte, error) {
+	return json.Marshal(s.record())
+}
+
+func (JSONSerializer) Unmarshal(b []byte, s *Session) error {
+	var rec sessionRecord
+	err := json.Unmarshal(b, &rec)
+	if err != nil {
+		return err
+	}
+	s.fromRecord(rec)
+	return nil
+}
+
+// RegisterSessionType registers the type of v with encoding/gob, so that
+// values of that type can be stored in sessions serialized with the
+// GobSerializer. It should be called during initialization.
+func RegisterSessionType(v interface{}) {
+	gob.Register(v)
+}
+
+func (s *Session) fromRecord(rec sessionRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.id = rec.ID
+	s.data = rec.Data
+	if s.data == nil {
+		s.data = make(map[string]interface{})
+	}
+	s.created = rec.Created
+	if s.created.IsZero() {
+		// stored before sessions had a creation time
+		s.created = time.Now()
+	}
+	s.expires = rec.Expires
+}
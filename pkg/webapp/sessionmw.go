@@ -0,0 +1,97 @@
+package webapp
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// sessionKey is the context key for the session of a request
+type sessionKey struct{}
+
+// SessionFrom returns the session of a request that has been through
+// the session middleware. There is always a session, but a new session
+// is only saved if something is stored in it. It returns nil if the
+// request has not been through the middleware.
+func SessionFrom(r *http.Request) *Session {
+	sess, _ := r.Context().Value(sessionKey{}).(*Session)
+	return sess
+}
+
+// Middleware returns middleware that loads the session of each request
+// into the request context (see SessionFrom) and saves it automatically.
+// The session is saved when it has been changed, and also once half of
+// the session IdleTimeout has passed, so the expiry slides while the client
+// is active without saving it on every request. The session cookie is
+// written just before the response headers, so handlers don't need to
+// call Save, and sessions that have been destroyed are removed.
+func (ss *SessionStore) Middleware() Middleware {
+	return sessionMiddleware(ss, ss.IdleTimeout)
+}
+
+// Middleware returns the session middleware for the cookie session
+// store, see SessionStore.Middleware
+func (cs *CookieSessionStore) Middleware() Middleware {
+	return sessionMiddleware(cs, cs.IdleTimeout)
+}
+
+func sessionMiddleware(sm SessionManager, timeout time.Duration) Middleware {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			sess, ok := sm.Get(r)
+			if !ok {
+				sess = sm.New()
+			}
+			sw := &sessionWriter{ResponseWriter: w}
+			sw.save = func() {
+				modified, destroyed, expires := sess.state()
+				switch {
+				case destroyed:
+					if ok {
+						sm.Save(w, r, nil)
+					}
+				case modified:
+					sm.Save(w, r, sess)
+				case ok && time.Until(expires) < timeout/2:
+					sm.Save(w, r, sess)
+				}
+			}
+			next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), sessionKey{}, sess)))
+			sw.commit()
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// sessionWriter saves the session before the response headers are
+// written, as the cookie can't be set once they have been
+type sessionWriter struct {
+	http.ResponseWriter
+	save      func()
+	committed bool
+}
+
+func (w *sessionWriter) commit() {
+	if w.committed {
+		return
+	}
+	w.committed = true
+	w.save()
+}
+
+func (w *sessionWriter) WriteHeader(code int) {
+	w.commit()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *sessionWriter) Write(b []byte) (int, error) {
+	w.commit()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *sessionWriter) Flush() {
+	w.commit()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
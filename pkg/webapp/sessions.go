@@ -1,9 +1,10 @@
 package webapp
 
 import (
+	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
-	"math/rand"
 	"net/http"
 	"net/url"
 	"strings"
@@ -27,85 +28,245 @@ func AddTime(t time.Time, duration time.Duration) time.Time {
 	return t.Add(duration)
 }
 
+// Session holds the data of a single client. A session kept in memory
+// is shared by all the requests of the client, so it is safe for
+// concurrent use.
 type Session struct {
-	id      string
-	data    map[string]interface{}
-	expires time.Time
+	mu        sync.RWMutex
+	id        string
+	oldID     string // oldID is the id replaced by Regenerate
+	data      map[string]interface{}
+	created   time.Time // created is when the id was issued
+	expires   time.Time
+	modified  bool // modified is set when the data changes
+	destroyed bool // destroyed is set by Destroy
+}
+
+func newSession(expires time.Time) *Session {
+	return &Session{
+		id:      NewSessionID(),
+		data:    make(map[string]interface{}),
+		created: time.Now(),
+		expires: expires,
+	}
 }
 
 func (s *Session) ID() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.id
 }
 
 func (s *Session) Has(k string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	_, ok := s.data[k]
 	return ok
 }
 
 func (s *Session) Set(k string, val interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.data[k] = val
+	s.modified = true
 }
 
 func (s *Session) Get(k string) (interface{}, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	v, ok := s.data[k]
 	return v, ok
 }
 
 func (s *Session) Del(k string) {
-	delete(s.data, k)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data[k]; ok {
+		delete(s.data, k)
+		s.modified = true
+	}
+}
+
+// Regenerate gives the session a new id, keeping its data, and restarts
+// its absolute lifetime. Call it whenever the privileges of the session
+// change, on login in particular, so that an id an attacker planted
+// beforehand (session fixation) is of no use afterwards. The old id is
+// removed from the store when the session is next saved.
+func (s *Session) Regenerate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.oldID == "" {
+		s.oldID = s.id
+	}
+	s.id = NewSessionID()
+	s.created = time.Now()
+	s.modified = true
+}
+
+// rotate gives the session a new id, keeping its data, like Regenerate,
+// but without restarting its absolute lifetime
+func (s *Session) rotate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.oldID == "" {
+		s.oldID = s.id
+	}
+	s.id = NewSessionID()
+	s.modified = true
+}
+
+// Destroy marks the session to be removed by the session middleware
+// at the end of the request, along with the session cookie
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.destroyed = true
 }
 
 func (s *Session) ExpiresIn() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.expires.Unix() - time.Now().Unix()
 }
 
+// expired reports whether the session has expired
+func (s *Session) expired() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return !time.Now().Before(s.expires)
+}
+
+// state returns the flags the session middleware saves the session by
+func (s *Session) state() (modified, destroyed bool, expires time.Time) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.modified, s.destroyed, s.expires
+}
+
+// renew sets the expiry of the session as it is saved, and returns the
+// id replaced by Regenerate, if any, which the store should remove
+func (s *Session) renew(conf *SessionConfig) (oldID string) {
+	return s.renewBy(conf.expiry)
+}
+
+// renewBy is renew with the expiry worked out by the function, from when
+// the session was created, for sessions with other timeouts than those
+// of the session config, eg. refresh tokens
+func (s *Session) renewBy(expiry func(created time.Time) time.Time) (oldID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expires = expiry(s.created)
+	s.modified = false
+	oldID, s.oldID = s.oldID, ""
+	return oldID
+}
+
+// record returns the stored form of the session
+func (s *Session) record() sessionRecord {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return sessionRecord{ID: s.id, Data: s.data, Created: s.created, Expires: s.expires}
+}
+
 // SessionConfig is a configuration object for a
 // session manager
 type SessionConfig struct {
-	SessionID string        // SessionID is the global session id
-	Domain    string        // Domain is the domain to limit the session scope
-	Timeout   time.Duration // Timeout is the max idle session time allowed
+	SessionID       string        // SessionID is the global session id
+	IdleTimeout     time.Duration // IdleTimeout is the max idle session time allowed
+	AbsoluteTimeout time.Duration // AbsoluteTimeout is the max session lifetime, however active
+	Timeout         time.Duration // Timeout is the old name of IdleTimeout, used if it is not set
+	Keys            [][]byte      // Keys sign cookies, the first signs new ones (see below)
+	Encrypt         bool          // Encrypt encrypts cookies with AES-GCM as well as signing them
+
+	Path     string        // Path limits the session scope to a path, the default is "/"
+	Domain   string        // Domain widens the session scope to a domain, if empty the cookie is host-only
+	Secure   bool          // Secure only sends the session cookie over HTTPS
+	SameSite http.SameSite // SameSite is the SameSite mode of the cookie, the default is strict
+
+	Backend    SessionKV         // Backend stores the sessions, they are kept in memory if nil
+	Serializer SessionSerializer // Serializer is used with the Backend, the default is gob
+	Logger     *Logger           // Logger reports Backend errors, if set
 }
 
+// Session cookies are signed with HMAC-SHA256, using keys derived from the
+// first of the SessionConfig Keys. Cookies signed with any of the keys are
+// accepted, so keys can be rotated by adding a new key to the front of the
+// list, and removing the last one once the cookies it signed have expired.
+// Keys must be at least 16 bytes long (32 random bytes is recommended.) If
+// there are no keys a random key is used, and cookies from before a
+// restart will be rejected.
+
 // defaultSessionConfig is pretty self explanatory
 var defaultSessionConfig = &SessionConfig{
-	SessionID: "go_sess_id",
-	Domain:    "localhost",
-	Timeout:   time.Duration(15) * time.Minute,
+	SessionID:       "go_sess_id",
+	IdleTimeout:     time.Duration(15) * time.Minute,
+	AbsoluteTimeout: time.Duration(12) * time.Hour,
+	Path:            "/",
+	SameSite:        http.SameSiteStrictMode,
 }
 
 // checkConfig checks the SessionConfig and sets
 // and default values that need to be set
-func checkConfig(conf *SessionConfig) {
+func checkConfig(conf *SessionConfig) *SessionConfig {
 	if conf == nil {
-		conf = defaultSessionConfig
+		conf = new(SessionConfig)
 	}
 	if conf.SessionID == "" {
 		conf.SessionID = defaultSessionConfig.SessionID
 	}
-	if conf.Domain == "" {
-		conf.Domain = defaultSessionConfig.Domain
+	if conf.IdleTimeout == 0 {
+		conf.IdleTimeout = conf.Timeout
+	}
+	if conf.IdleTimeout == 0 {
+		conf.IdleTimeout = defaultSessionConfig.IdleTimeout
+	}
+	conf.Timeout = conf.IdleTimeout
+	if conf.AbsoluteTimeout == 0 {
+		conf.AbsoluteTimeout = defaultSessionConfig.AbsoluteTimeout
+	}
+	if conf.Path == "" {
+		conf.Path = defaultSessionConfig.Path
 	}
-	if conf.Timeout == 0 {
-		conf.Timeout = defaultSessionConfig.Timeout
+	if conf.SameSite == 0 {
+		conf.SameSite = defaultSessionConfig.SameSite
 	}
+	if conf.Backend != nil && conf.Serializer == nil {
+		conf.Serializer = GobSerializer{}
+	}
+	return conf
+}
+
+// expiry returns when a session created at the given time expires if it
+// is saved now, which is the idle timeout from now, unless the absolute
+// timeout is reached first
+func (conf *SessionConfig) expiry(created time.Time) time.Time {
+	expires := AddTime(time.Now(), conf.IdleTimeout)
+	if conf.AbsoluteTimeout > 0 {
+		if end := AddTime(created, conf.AbsoluteTimeout); end.Before(expires) {
+			expires = end
+		}
+	}
+	return expires
 }
 
-// SessionStore implements the session manager interface
-// and is a basic session manager using cookies.
+// SessionStore implements the session manager interface and is a
+// basic session manager using cookies. Sessions are kept in memory
+// unless the config has a Backend to keep them in.
 type SessionStore struct {
 	*SessionConfig
 	sessions *sync.Map
+	codec    *cookieCodec
 }
 
 // NewSessionStore takes a session id and a make session timeout. The sid
 // will be used as the key for all session cookies, and the timeout is the
 // maximum allowable idle session time before the session is expired
 func NewSessionStore(conf *SessionConfig) *SessionStore {
-	checkConfig(conf)
+	conf = checkConfig(conf)
 	ss := &SessionStore{
 		SessionConfig: conf,
 		sessions:      new(sync.Map),
+		codec:         newCookieCodec(conf.Keys, conf.Encrypt),
 	}
 	go ss.gc()
 	return ss
@@ -113,44 +274,133 @@ func NewSessionStore(conf *SessionConfig) *SessionStore {
 
 // New creates and returns a new session
 func (ss *SessionStore) New() *Session {
-	return &Session{
-		id:      RandStringN(32), // create session id 32 chars long
-		data:    make(map[string]interface{}),
-		expires: AddTime(time.Now(), ss.Timeout),
-	}
+	return newSession(ss.expiry(time.Now()))
 }
 
 // Get returns a cached session (if one exists)
 func (ss *SessionStore) Get(r *http.Request) (*Session, bool) {
-	c := getCookie(r, ss.SessionID)
-	if c == nil {
-		return nil, false
-	}
-	v, ok := ss.sessions.Load(c.Value)
+	id, ok := ss.readCookie(r)
 	if !ok {
 		return nil, false
 	}
-	return v.(*Session), true
+	return ss.load(id)
 }
 
 // Save persists the provided session. If you would like to remove a session, simply
 // pass it a nil session, and it will time the cookie out.
 func (ss *SessionStore) Save(w http.ResponseWriter, r *http.Request, session *Session) {
 	if session == nil {
-		cook := getCookie(r, ss.SessionID)
-		if cook == nil {
-			return
+		id, ok := ss.readCookie(r)
+		if ok {
+			ss.delete(id)
+		}
+		if getCookie(r, ss.SessionID) != nil {
+			http.SetCookie(w, ss.newCookie(ss.SessionID, "", time.Now()))
+		}
+		return
+	}
+	if oldID := session.renew(ss.SessionConfig); oldID != "" {
+		ss.delete(oldID)
+	}
+	if session.expired() {
+		// the absolute timeout has been reached
+		ss.Save(w, r, nil)
+		return
+	}
+	if !ss.store(session) {
+		return
+	}
+	rec := session.record()
+	v, err := ss.codec.encode(ss.SessionID, []byte(rec.ID))
+	if err != nil {
+		return
+	}
+	http.SetCookie(w, ss.newCookie(ss.SessionID, v, rec.Expires))
+}
+
+// readCookie returns the session id from the session cookie of the
+// request, if there is one and it has not been tampered with
+func (ss *SessionStore) readCookie(r *http.Request) (string, bool) {
+	c := getCookie(r, ss.SessionID)
+	if c == nil {
+		return "", false
+	}
+	id, err := ss.codec.decode(ss.SessionID, c.Value, ss.IdleTimeout)
+	if err != nil {
+		return "", false
+	}
+	return string(id), true
+}
+
+// load returns the session with the id from memory or the backend
+func (ss *SessionStore) load(id string) (*Session, bool) {
+	if ss.Backend == nil {
+		v, ok := ss.sessions.Load(id)
+		if !ok || v.(*Session).expired() {
+			return nil, false
+		}
+		return v.(*Session), true
+	}
+	b, err := ss.Backend.Get(id)
+	if err != nil {
+		if !errors.Is(err, ErrSessionNotFound) {
+			ss.logError("loading session", err)
 		}
-		ss.sessions.Delete(cook.Value)
-		http.SetCookie(w, newCookie(ss.SessionID, cook.Value, ss.Domain, time.Now()))
+		return nil, false
+	}
+	session := new(Session)
+	err = ss.Serializer.Unmarshal(b, session)
+	if err != nil {
+		ss.logError("decoding session", err)
+		return nil, false
+	}
+	if session.ID() != id || session.expired() {
+		return nil, false
+	}
+	return session, true
+}
+
+// store keeps the session in memory or writes it to the backend,
+// and reports whether it succeeded
+func (ss *SessionStore) store(session *Session) bool {
+	if ss.Backend == nil {
+		ss.sessions.Store(session.ID(), session)
+		return true
+	}
+	b, err := ss.Serializer.Marshal(session)
+	if err != nil {
+		ss.logError("encoding session", err)
+		return false
+	}
+	rec := session.record()
+	err = ss.Backend.Set(rec.ID, b, rec.Expires)
+	if err != nil {
+		ss.logError("storing session", err)
+		return false
+	}
+	return true
+}
+
+// delete removes the session with the id from memory or the backend
+func (ss *SessionStore) delete(id string) {
+	if ss.Backend == nil {
+		ss.sessions.Delete(id)
 		return
 	}
-	session.expires = AddTime(time.Now(), ss.Timeout)
-	ss.sessions.Store(session.id, session)
-	http.SetCookie(w, newCookie(ss.SessionID, session.id, ss.Domain, session.expires))
+	err := ss.Backend.Delete(id)
+	if err != nil {
+		ss.logError("deleting session", err)
+	}
+}
+
+func (ss *SessionStore) logError(what string, err error) {
+	if ss.Logger != nil {
+		ss.Logger.Error("sessions: %s: %s\n", what, err)
+	}
 }
 
-// String is the session store's stringer method
+// String is the session store's stringer method, it
+// lists the ids of the sessions that are kept in memory
 func (ss *SessionStore) String() string {
 	var sessions []string
 	ss.sessions.Range(func(id, sess interface{}) bool {
@@ -163,29 +413,36 @@ func (ss *SessionStore) String() string {
 // gc is the session store "garbage collector" and
 // cleans and disposes of expired sessions (server side)
 func (ss *SessionStore) gc() {
+	if e, ok := ss.Backend.(SessionExpirer); ok {
+		err := e.DeleteExpired()
+		if err != nil {
+			ss.logError("deleting expired sessions", err)
+		}
+	}
 	ss.sessions.Range(func(id, sess interface{}) bool {
-		if sess.(*Session).ExpiresIn() < 0 {
+		if sess.(*Session).expired() {
 			ss.sessions.Delete(id)
 		}
 		return true
 	})
-	time.AfterFunc(ss.Timeout/2, func() { ss.gc() })
+	time.AfterFunc(ss.IdleTimeout/2, func() { ss.gc() })
 }
 
-// newCookie is a helper that wraps the creation of a new
-// cookie and returns a filled out *http.Cookie instance
-func newCookie(name, value, domain string, expires time.Time) *http.Cookie {
+// newCookie is a helper that wraps the creation of a new cookie
+// and returns a filled out *http.Cookie instance, using the cookie
+// attributes of the config
+func (conf *SessionConfig) newCookie(name, value string, expires time.Time) *http.Cookie {
 	return &http.Cookie{
 		Name:       URLEncode(name),
-		Value:      Base64Encode(value),
-		Path:       "/",
-		Domain:     domain,
+		Value:      value,
+		Path:       conf.Path,
+		Domain:     conf.Domain, // empty for a host-only cookie
 		Expires:    expires,
 		RawExpires: "",
 		MaxAge:     setMaxAge(expires),
-		Secure:     false,                   // set to true, if using TLS (false otherwise)
-		HttpOnly:   true,                    // protects against XSS attacks
-		SameSite:   http.SameSiteStrictMode, // protects against CSRF attacks
+		Secure:     conf.Secure, // set to true, if using TLS (false otherwise)
+		HttpOnly:   true,        // protects against XSS attacks
+		SameSite:   conf.SameSite,
 		Raw:        "",
 		Unparsed:   nil,
 	}
@@ -208,35 +465,44 @@ func setMaxAge(expires time.Time) int {
 // expired in the meantime.
 func getCookie(r *http.Request, name string) *http.Cookie {
 	c, err := r.Cookie(URLEncode(name))
-	if err != nil || err == http.ErrNoCookie {
+	if err != nil {
 		return nil
 	}
-	c.Value = Base64Decode(c.Value)
 	return c
 }
 
-const (
-	letterBytes   = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
-	letterIdxBits = 6                    // 6 bits to represent a letter index
-	letterIdxMask = 1<<letterIdxBits - 1 // All 1-bits, as many as letterIdxBits
-	letterIdxMax  = 63 / letterIdxBits   // # of letter indices fitting in 63 bits
-)
+const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// NewSessionID returns a new session id made from 32
+// bytes read from crypto/rand, encoded as base64
+func NewSessionID() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic("sessions: reading random bytes: " + err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
 
-// RandStringN creates a random string N characters in length
+// RandStringN creates a random string N characters in length, drawing
+// the letters from crypto/rand
 func RandStringN(n int) string {
-	var src = rand.NewSource(time.Now().UnixNano() + int64(rand.Uint64()))
 	b := make([]byte, n)
-	// A src.Int63() generates 63 random bits, enough for letterIdxMax characters!
-	for i, cache, remain := n-1, src.Int63(), letterIdxMax; i >= 0; {
-		if remain == 0 {
-			cache, remain = src.Int63(), letterIdxMax
+	buf := make([]byte, n)
+	for i := 0; i < n; {
+		if _, err := rand.Read(buf); err != nil {
+			panic("sessions: reading random bytes: " + err.Error())
 		}
-		if idx := int(cache & letterIdxMask); idx < len(letterBytes) {
-			b[i] = letterBytes[idx]
-			i--
+		for _, c := range buf {
+			// 52 letters, reject the values that would bias the result
+			if c >= 255-(255%byte(len(letterBytes))) {
+				continue
+			}
+			b[i] = letterBytes[int(c)%len(letterBytes)]
+			i++
+			if i == n {
+				break
+			}
 		}
-		cache >>= letterIdxBits
-		remain--
 	}
 	return string(b)
 }
@@ -247,12 +513,12 @@ func Base64Encode(s string) string {
 }
 
 // Base64Decode takes a base64 encoded string and returns a plaintext string
-func Base64Decode(s string) string {
+func Base64Decode(s string) (string, error) {
 	b, err := base64.RawURLEncoding.DecodeString(s)
 	if err != nil {
-		panic(fmt.Sprintf("cookie: base64 decoding failed %q", err))
+		return "", fmt.Errorf("cookie: base64 decoding failed: %w", err)
 	}
-	return string(b)
+	return string(b), nil
 }
 
 // URLEncode takes a plaintext string and returns a URL encoded string
@@ -261,10 +527,10 @@ func URLEncode(s string) string {
 }
 
 // URLDecode takes a URL encoded string and returns a plaintext string
-func URLDecode(s string) string {
+func URLDecode(s string) (string, error) {
 	us, err := url.QueryUnescape(s)
 	if err != nil {
-		panic(fmt.Sprintf("cookie: query unescape failed %q", err))
+		return "", fmt.Errorf("cookie: query unescape failed: %w", err)
 	}
-	return us
+	return us, nil
 }
@@ -0,0 +1,82 @@
+package webapp
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SQLiteSessionKV is a SessionKV keeping sessions in a SQLite table. The
+// database is opened by the caller, who also has to import the driver:
+//
+//	import _ "github.com/mattn/go-sqlite3"
+//
+//	db, err := sql.Open("sqlite3", "sessions.sqlite")
+//	kv, err := webapp.NewSQLiteSessionKV(db, "sessions")
+type SQLiteSessionKV struct {
+	db    *sql.DB
+	table string
+}
+
+// NewSQLiteSessionKV returns a store keeping sessions in the named table,
+// creating the table if it does not exist
+func NewSQLiteSessionKV(db *sql.DB, table string) (*SQLiteSessionKV, error) {
+	if !validIdent(table) {
+		return nil, fmt.Errorf("sessions: invalid table name %q", table)
+	}
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS ` + table + ` (
+		id TEXT NOT NULL PRIMARY KEY,
+		data BLOB NOT NULL,
+		expires INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("sessions: creating table: %w", err)
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS ` + table + `_expires ON ` + table + ` (expires)`)
+	if err != nil {
+		return nil, fmt.Errorf("sessions: creating index: %w", err)
+	}
+	return &SQLiteSessionKV{db: db, table: table}, nil
+}
+
+func (kv *SQLiteSessionKV) Get(key string) ([]byte, error) {
+	var b []byte
+	err := kv.db.QueryRow(`SELECT data FROM `+kv.table+` WHERE id = ? AND expires >= ?`,
+		key, time.Now().Unix()).Scan(&b)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSessionNotFound
+	}
+	return b, err
+}
+
+func (kv *SQLiteSessionKV) Set(key string, value []byte, expires time.Time) error {
+	_, err := kv.db.Exec(`INSERT OR REPLACE INTO `+kv.table+` (id, data, expires) VALUES (?, ?, ?)`,
+		key, value, expires.Unix())
+	return err
+}
+
+func (kv *SQLiteSessionKV) Delete(key string) error {
+	_, err := kv.db.Exec(`DELETE FROM `+kv.table+` WHERE id = ?`, key)
+	return err
+}
+
+// DeleteExpired removes every expired session from the table
+func (kv *SQLiteSessionKV) DeleteExpired() error {
+	_, err := kv.db.Exec(`DELETE FROM `+kv.table+` WHERE expires < ?`, time.Now().Unix())
+	return err
+}
+
+// validIdent reports whether s can be used as an SQL identifier as is
+func validIdent(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || i > 0 && c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -1,6 +1,8 @@
 package webapp
 
 import (
+	"bytes"
+	"fmt"
 	"html/template"
 	"net/http"
 	"os"
@@ -25,6 +27,23 @@ func NewTemplateCache(conf *TemplateConfig) *TemplateCache {
 	if conf.FuncMap == nil {
 		conf.FuncMap = template.FuncMap{}
 	}
+	if _, ok := conf.FuncMap["url"]; !ok {
+		// placeholder, so templates using url can be parsed
+		// before a muxer has been attached with Funcs
+		conf.FuncMap["url"] = noMuxerURL
+	}
+	if _, ok := conf.FuncMap["csrfField"]; !ok {
+		// takes the request, eg. {{ csrfField .Request }}
+		conf.FuncMap["csrfField"] = CSRFField
+	}
+	if _, ok := conf.FuncMap["flashes"]; !ok {
+		// takes the request, eg. {{ flashes .Request }}
+		conf.FuncMap["flashes"] = RenderFlashes
+	}
+	if _, ok := conf.FuncMap["flashAlerts"]; !ok {
+		// takes flash messages, eg. {{ flashAlerts .Flashes }}
+		conf.FuncMap["flashAlerts"] = FlashAlerts
+	}
 	tc := &TemplateCache{
 		TemplateConfig: conf,
 	}
@@ -37,6 +56,22 @@ func NewTemplateCache(conf *TemplateConfig) *TemplateCache {
 	return tc
 }
 
+// Funcs adds the supplied functions to the function map of the
+// template cache, replacing any existing functions with the same name.
+// Functions used by the templates must exist before they are parsed,
+// but may be replaced at any time.
+func (tc *TemplateCache) Funcs(funcMap template.FuncMap) {
+	for name, fn := range funcMap {
+		tc.FuncMap[name] = fn
+	}
+	tc.t.Funcs(funcMap)
+}
+
+// noMuxerURL is the "url" template function used until a muxer is attached
+func noMuxerURL(name string, params ...interface{}) (string, error) {
+	return "", fmt.Errorf("url: no muxer attached to template cache, cannot build route %q", name)
+}
+
 func (tc *TemplateCache) ParseGlob(pattern string) {
 	t, err := tc.t.Funcs(tc.FuncMap).ParseGlob(pattern)
 	if err != nil {
@@ -45,15 +80,21 @@ func (tc *TemplateCache) ParseGlob(pattern string) {
 	tc.t = t
 }
 
+// ExecuteTemplate renders the named template to the response. The output
+// is buffered, so nothing is written until the whole template has been
+// executed, and template functions such as "flashes" can still change
+// the session before the session cookie is written.
 func (tc *TemplateCache) ExecuteTemplate(w http.ResponseWriter, name string, data interface{}) {
-	w.Header().Set("content-type", "text/html; charset=utf-8")
-	err := tc.t.ExecuteTemplate(w, name, data)
+	buf := new(bytes.Buffer)
+	err := tc.t.ExecuteTemplate(buf, name, data)
 	if err != nil {
 		//code := http.StatusExpectationFailed
 		//http.Error(w, http.StatusText(code), code)
 		http.RedirectHandler("/error/417", http.StatusTemporaryRedirect)
 		return
 	}
+	w.Header().Set("content-type", "text/html; charset=utf-8")
+	_, _ = buf.WriteTo(w)
 }
 
 func (tc *TemplateCache) DefinedTemplates() string {
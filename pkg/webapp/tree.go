@@ -0,0 +1,180 @@
+package webapp
+
+import (
+	"strings"
+)
+
+// node is a single node in the muxer's radix tree. Static text is
+// stored compressed in the prefix of a node, while named parameters
+// and catch-all wildcards get nodes of their own. When matching, the
+// static children are tried first, then typed parameters, then plain
+// parameters and finally the wildcard, backtracking as needed.
+type node struct {
+	prefix   string    // compressed static text, empty for param and wildcard nodes
+	indices  string    // first byte of each static child, in children order
+	children []*node   // static children
+	params   []*node   // parameter children, typed parameters first
+	wildcard *node     // catch-all child
+	seg      segment   // the segment a param or wildcard node represents
+	entry    *muxEntry // the route terminating at this node, if any
+}
+
+// pathParam is a single captured path parameter
+type pathParam struct {
+	key   string
+	value string
+}
+
+// insert adds the supplied entry to the tree rooted at n
+func (n *node) insert(e *muxEntry) {
+	text := pathSeperator
+	for i, seg := range e.segs {
+		last := i == len(e.segs)-1
+		switch seg.kind {
+		case segStatic:
+			text += seg.value
+			if !last {
+				text += pathSeperator
+			}
+		case segParam:
+			n = n.addStatic(text).addParam(seg)
+			text = ""
+			if !last {
+				text = pathSeperator
+			}
+		case segWildcard:
+			n = n.addStatic(text).addWildcard(seg, e.pattern)
+			text = ""
+		}
+	}
+	n = n.addStatic(text)
+	if n.entry != nil && n.entry != e {
+		panic("http: pattern " + e.pattern + " conflicts with " + n.entry.pattern)
+	}
+	n.entry = e
+}
+
+// addStatic walks (and splits, if need be) the static children of n to
+// add the supplied text, returning the node the text ends at
+func (n *node) addStatic(text string) *node {
+	for text != "" {
+		i := strings.IndexByte(n.indices, text[0])
+		if i < 0 {
+			child := &node{prefix: text}
+			n.indices += text[:1]
+			n.children = append(n.children, child)
+			return child
+		}
+		child := n.children[i]
+		l := commonPrefix(child.prefix, text)
+		if l < len(child.prefix) {
+			// split the child, keeping the same pointer so
+			// the parent does not need to be updated
+			split := *child
+			split.prefix = child.prefix[l:]
+			*child = node{
+				prefix:   child.prefix[:l],
+				indices:  split.prefix[:1],
+				children: []*node{&split},
+			}
+		}
+		n, text = child, text[l:]
+	}
+	return n
+}
+
+// addParam returns the param child of n matching seg, creating it if needed
+func (n *node) addParam(seg segment) *node {
+	for _, p := range n.params {
+		if p.seg.value == seg.value && p.seg.typ == seg.typ {
+			return p
+		}
+	}
+	child := &node{seg: seg}
+	// keep the typed parameters ahead of the untyped ones
+	i := len(n.params)
+	for i > 0 && n.params[i-1].seg.rank() > seg.rank() {
+		i--
+	}
+	n.params = append(n.params, nil)
+	copy(n.params[i+1:], n.params[i:])
+	n.params[i] = child
+	return child
+}
+
+// addWildcard returns the wildcard child of n, creating it if needed
+func (n *node) addWildcard(seg segment, pattern string) *node {
+	if n.wildcard == nil {
+		n.wildcard = &node{seg: seg}
+	}
+	if n.wildcard.seg.value != seg.value {
+		panic("http: wildcard in pattern " + pattern + " conflicts with *" + n.wildcard.seg.value)
+	}
+	return n.wildcard
+}
+
+// lookup finds the most specific entry in the tree rooted at n (whose
+// own prefix has already been consumed) for the remaining path. Any
+// captured path parameters are appended to ps.
+func (n *node) lookup(path string, ps *[]pathParam) *muxEntry {
+	if path == "" {
+		if n.entry != nil {
+			return n.entry
+		}
+		if n.wildcard != nil {
+			return n.wildcard.capture(path, ps)
+		}
+		return nil
+	}
+	// static children first
+	if i := strings.IndexByte(n.indices, path[0]); i > -1 {
+		child := n.children[i]
+		if strings.HasPrefix(path, child.prefix) {
+			if e := child.lookup(path[len(child.prefix):], ps); e != nil {
+				return e
+			}
+		}
+	}
+	// then parameters, which match up to the next separator
+	if len(n.params) > 0 {
+		end := strings.IndexByte(path, '/')
+		if end < 0 {
+			end = len(path)
+		}
+		if val := path[:end]; val != "" {
+			for _, p := range n.params {
+				if p.seg.check != nil && !p.seg.check(val) {
+					continue
+				}
+				mark := len(*ps)
+				*ps = append(*ps, pathParam{key: p.seg.value, value: val})
+				if e := p.lookup(path[end:], ps); e != nil {
+					return e
+				}
+				*ps = (*ps)[:mark]
+			}
+		}
+	}
+	// and lastly the catch-all
+	if n.wildcard != nil {
+		return n.wildcard.capture(path, ps)
+	}
+	return nil
+}
+
+// capture records the remaining path for a wildcard node
+func (n *node) capture(path string, ps *[]pathParam) *muxEntry {
+	if n.seg.value != "" {
+		*ps = append(*ps, pathParam{key: n.seg.value, value: path})
+	}
+	return n.entry
+}
+
+// commonPrefix returns the length of the common prefix of a and b
+func commonPrefix(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
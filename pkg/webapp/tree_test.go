@@ -0,0 +1,109 @@
+package webapp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestMuxerMatch(t *testing.T) {
+	mux := NewMuxer(&MuxerConfig{Logging: LevelOff})
+	patterns := []string{
+		"/",
+		"/a/b",
+		"/a/:x/c",
+		"/a/b/:y",
+		"/a/*rest",
+		"/n/:id|int",
+		"/n/:name",
+		"/t/:id|int/edit",
+		"/t/:slug/view",
+		"/docs/",
+		"/users/:id/",
+	}
+	for _, p := range patterns {
+		p := p
+		mux.HandleFunc(http.MethodGet, p, func(w http.ResponseWriter, r *http.Request) {
+			var ps []string
+			for k, v := range Params(r) {
+				ps = append(ps, k+"="+v)
+			}
+			sort.Strings(ps)
+			w.Write([]byte(strings.TrimSpace(p + " " + strings.Join(ps, " "))))
+		})
+	}
+	tests := []struct {
+		path string
+		want string // the pattern and its parameters, or empty for a 404
+	}{
+		{"/", "/"},
+		{"/a/b", "/a/b"},
+		// static segments win, then parameters, then the wildcard
+		{"/a/b/c", "/a/b/:y y=c"},
+		{"/a/z/c", "/a/:x/c x=z"},
+		{"/a/b/d/e", "/a/*rest rest=b/d/e"},
+		{"/a/z/d", "/a/*rest rest=z/d"},
+		{"/a/", "/a/*rest rest="},
+		// typed parameters win, and fall back to untyped ones
+		{"/n/42", "/n/:id|int id=42"},
+		{"/n/abc", "/n/:name name=abc"},
+		{"/t/42/edit", "/t/:id|int/edit id=42"},
+		{"/t/42/view", "/t/:slug/view slug=42"},
+		{"/t/abc/edit", ""},
+		// empty segments don't match parameters
+		{"/n/", ""},
+		{"/t//view", ""},
+		{"/a//c", "/a/*rest rest=/c"},
+		// trailing slashes only match prefix patterns
+		{"/a/b/", "/a/*rest rest=b/"},
+		{"/n/42/", ""},
+		{"/docs", ""},
+		{"/docs/", "/docs/"},
+		{"/docs/x/y", "/docs/"},
+		{"/users/7/", "/users/:id/ id=7"},
+		{"/users/7/posts", "/users/:id/ id=7"},
+		{"/users/7", ""},
+		{"/nowhere", ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		got := w.Body.String()
+		if w.Code == http.StatusNotFound {
+			got = ""
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestMuxerConflicts(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		name     string
+		patterns []string
+	}{
+		{"prefix and unnamed wildcard", []string{"/a/", "/a/*"}},
+		{"differently named wildcards", []string{"/a/*x", "/a/*y"}},
+		{"same method twice", []string{"/a/:x", "/a/:x"}},
+		{"wildcard not last", []string{"/a/*x/b"}},
+		{"unknown parameter type", []string{"/a/:x|float"}},
+		{"empty segment", []string{"/a//b"}},
+	}
+	for _, tt := range tests {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: no panic for %v", tt.name, tt.patterns)
+				}
+			}()
+			mux := NewMuxer(&MuxerConfig{Logging: LevelOff})
+			for _, p := range tt.patterns {
+				mux.Get(p, h)
+			}
+		}()
+	}
+}
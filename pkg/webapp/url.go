@@ -0,0 +1,187 @@
+package webapp
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Route is returned when registering a handler, so that the
+// route can be given a name for use with Muxer.URL
+type Route struct {
+	mux     *Muxer
+	method  string
+	pattern string
+}
+
+// Method returns the method the route was registered with
+func (rt *Route) Method() string {
+	return rt.method
+}
+
+// Pattern returns the full pattern the route was registered with
+func (rt *Route) Pattern() string {
+	return rt.pattern
+}
+
+// Name gives the route a name that can be used to build URLs for it
+// with Muxer.URL (or the "url" template function.) Names are unique
+// per muxer, it panics if the name is already in use by another pattern.
+func (rt *Route) Name(name string) *Route {
+	if name == "" {
+		panic("http: invalid route name")
+	}
+	rt.mux.lock.Lock()
+	defer rt.mux.lock.Unlock()
+	if p, exist := rt.mux.names[name]; exist && p != rt.pattern {
+		panic("http: route name " + name + " already used for " + p)
+	}
+	rt.mux.names[name] = rt.pattern
+	return rt
+}
+
+// ErrRouteNotFound is returned by Muxer.URL for unknown route names
+var ErrRouteNotFound = errors.New("url: no route with that name")
+
+// URL builds the path for the named route. Parameters are provided as
+// key and value pairs, eg. URL("widget-part", "slug", "foo", "id", 42),
+// and are path escaped. Typed parameters are checked against their type,
+// and a wildcard value keeps its slashes. Other parameters can't contain
+// a slash, as the muxer matches the unescaped path, where it would
+// separate segments. Any pairs not used by the route
+// pattern are added to the query string. Routes of mounted muxers can be
+// reached by name as well, and are returned with their full paths.
+func (s *Muxer) URL(name string, params ...interface{}) (string, error) {
+	if len(params)%2 != 0 {
+		return "", fmt.Errorf("url: odd number of parameters for route %q", name)
+	}
+	vals := make(map[string]string, len(params)/2)
+	var keys []string
+	for i := 0; i < len(params); i += 2 {
+		k, ok := params[i].(string)
+		if !ok {
+			return "", fmt.Errorf("url: parameter name %v for route %q is not a string", params[i], name)
+		}
+		if _, dup := vals[k]; !dup {
+			keys = append(keys, k)
+		}
+		vals[k] = fmt.Sprint(params[i+1])
+	}
+	pattern, prefix, ok := s.lookupName(name)
+	if !ok {
+		return "", ErrRouteNotFound
+	}
+	p, err := buildPath(pattern, vals)
+	if err != nil {
+		return "", fmt.Errorf("url: route %q: %w", name, err)
+	}
+	p = prefix + p
+	if len(vals) > 0 {
+		q := make(url.Values, len(vals))
+		for _, k := range keys {
+			if v, ok := vals[k]; ok {
+				q.Set(k, v)
+			}
+		}
+		p += "?" + q.Encode()
+	}
+	return p, nil
+}
+
+// MustURL is like URL but panics if the path cannot be built
+func (s *Muxer) MustURL(name string, params ...interface{}) string {
+	u, err := s.URL(name, params...)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
+
+// ForwardTo registers a redirect from oldpattern to the named route. The
+// target path is built when a request comes in, so the named route may
+// be registered after the forward.
+func (s *Muxer) ForwardTo(oldpattern string, name string, params ...interface{}) *Route {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		u, err := s.URL(name, params...)
+		if err != nil {
+			if s.withLogging {
+				s.logger.Error("forward %s: %s\n", oldpattern, err)
+			}
+			s.Error(w, r, http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, u, http.StatusTemporaryRedirect)
+	}
+	return s.Handle(http.MethodGet, oldpattern, http.HandlerFunc(fn))
+}
+
+// URLFuncMap returns a template.FuncMap containing a "url" function
+// bound to the muxer, which behaves the same as Muxer.URL
+func (s *Muxer) URLFuncMap() template.FuncMap {
+	return template.FuncMap{
+		"url": s.URL,
+	}
+}
+
+// lookupName returns the pattern registered under name, along with
+// the mount prefix of the muxer it was found on
+func (s *Muxer) lookupName(name string) (string, string, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if pattern, ok := s.names[name]; ok {
+		return pattern, "", true
+	}
+	for prefix, sub := range s.mounts {
+		if pattern, p, ok := sub.lookupName(name); ok {
+			return pattern, prefix + p, true
+		}
+	}
+	return "", "", false
+}
+
+// buildPath fills in the parameters of pattern using vals, removing
+// each value from vals as it is used
+func buildPath(pattern string, vals map[string]string) (string, error) {
+	segs := parsePattern(pattern)
+	if segs == nil {
+		return pattern, nil
+	}
+	var sb strings.Builder
+	for _, seg := range segs {
+		switch seg.kind {
+		case segStatic:
+			sb.WriteString(pathSeperator)
+			sb.WriteString(seg.value)
+		case segParam:
+			v, ok := vals[seg.value]
+			if !ok || v == "" {
+				return "", fmt.Errorf("missing parameter %q", seg.value)
+			}
+			if seg.check != nil && !seg.check(v) {
+				return "", fmt.Errorf("parameter %q value %q is not of type %s", seg.value, v, seg.typ)
+			}
+			if strings.Contains(v, pathSeperator) {
+				return "", fmt.Errorf("parameter %q value %q contains a slash", seg.value, v)
+			}
+			delete(vals, seg.value)
+			sb.WriteString(pathSeperator)
+			sb.WriteString(url.PathEscape(v))
+		case segWildcard:
+			sb.WriteString(pathSeperator)
+			if seg.value == "" {
+				continue
+			}
+			v := strings.TrimPrefix(vals[seg.value], pathSeperator)
+			delete(vals, seg.value)
+			parts := strings.Split(v, pathSeperator)
+			for i := range parts {
+				parts[i] = url.PathEscape(parts[i])
+			}
+			sb.WriteString(strings.Join(parts, pathSeperator))
+		}
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,71 @@
+package webapp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestURLRoundTrip(t *testing.T) {
+	mux := NewMuxer(&MuxerConfig{Logging: LevelOff})
+	echo := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(Param(r, "slug") + "|" + Param(r, "id") + "|" + Param(r, "path") + "|" + r.URL.RawQuery))
+	}
+	mux.HandleFunc(http.MethodGet, "/widgets/:slug", echo).Name("widget")
+	mux.HandleFunc(http.MethodGet, "/widgets/:slug/parts/:id|int", echo).Name("part")
+	mux.HandleFunc(http.MethodGet, "/files/*path", echo).Name("file")
+	sub := NewMuxer(&MuxerConfig{Logging: LevelOff})
+	sub.HandleFunc(http.MethodGet, "/y/:slug", echo).Name("suby")
+	mux.Mount("/sub", sub)
+
+	tests := []struct {
+		name   string
+		params []interface{}
+		url    string
+		body   string
+	}{
+		{"widget", []interface{}{"slug", "foo"}, "/widgets/foo", "foo|||"},
+		{"widget", []interface{}{"slug", "a b?"}, "/widgets/a%20b%3F", "a b?|||"},
+		{"part", []interface{}{"slug", "foo", "id", 42}, "/widgets/foo/parts/42", "foo|42||"},
+		{"widget", []interface{}{"slug", "foo", "page", 2}, "/widgets/foo?page=2", "foo|||page=2"},
+		{"file", []interface{}{"path", "a b/c.txt"}, "/files/a%20b/c.txt", "||a b/c.txt|"},
+		{"suby", []interface{}{"slug", "a b"}, "/sub/y/a%20b", "a b|||"},
+	}
+	for _, tt := range tests {
+		u, err := mux.URL(tt.name, tt.params...)
+		if err != nil {
+			t.Errorf("URL(%q, %v): %v", tt.name, tt.params, err)
+			continue
+		}
+		if u != tt.url {
+			t.Errorf("URL(%q, %v) = %q, want %q", tt.name, tt.params, u, tt.url)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, u, nil))
+		if w.Code != http.StatusOK || w.Body.String() != tt.body {
+			t.Errorf("GET %s: %d %q, want %q", u, w.Code, w.Body.String(), tt.body)
+		}
+	}
+}
+
+func TestURLErrors(t *testing.T) {
+	mux := NewMuxer(&MuxerConfig{Logging: LevelOff})
+	h := func(w http.ResponseWriter, r *http.Request) {}
+	mux.HandleFunc(http.MethodGet, "/widgets/:slug/parts/:id|int", h).Name("part")
+	tests := []struct {
+		name   string
+		params []interface{}
+	}{
+		{"unknown", nil},
+		{"part", []interface{}{"slug", "foo"}},
+		{"part", []interface{}{"slug", "foo", "id"}},
+		{"part", []interface{}{"slug", "foo", "id", "x"}},
+		{"part", []interface{}{"slug", "a/b", "id", 1}},
+		{"part", []interface{}{"slug", "", "id", 1}},
+	}
+	for _, tt := range tests {
+		if u, err := mux.URL(tt.name, tt.params...); err == nil {
+			t.Errorf("URL(%q, %v) = %q, want an error", tt.name, tt.params, u)
+		}
+	}
+}
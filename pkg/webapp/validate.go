@@ -0,0 +1,379 @@
+package webapp
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// FieldError describes a single field that failed to bind or validate.
+// Field is the form name of the field, and Rule the validation rule that
+// failed ("type" when the value could not be converted.)
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	return e.Field + " " + e.Message
+}
+
+// ValidationErrors is the error returned by Bind and Validate, it holds
+// one FieldError for every field that failed, in field order
+type ValidationErrors []FieldError
+
+func (ve ValidationErrors) Error() string {
+	msgs := make([]string, len(ve))
+	for i, e := range ve {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Get returns the message for the named field, or an empty string
+func (ve ValidationErrors) Get(field string) string {
+	for _, e := range ve {
+		if e.Field == field {
+			return e.Message
+		}
+	}
+	return ""
+}
+
+// Map returns the messages keyed by field name, which is the form
+// forms.Form.SetErrors expects them in
+func (ve ValidationErrors) Map() map[string]string {
+	m := make(map[string]string, len(ve))
+	for _, e := range ve {
+		if _, ok := m[e.Field]; !ok {
+			m[e.Field] = e.Message
+		}
+	}
+	return m
+}
+
+// dedupe keeps only the first error for each field, so a value that
+// could not be converted is not also reported as missing
+func (ve ValidationErrors) dedupe() ValidationErrors {
+	seen := make(map[string]bool, len(ve))
+	out := ve[:0]
+	for _, e := range ve {
+		if seen[e.Field] {
+			continue
+		}
+		seen[e.Field] = true
+		out = append(out, e)
+	}
+	return out
+}
+
+// Validate checks the struct (or pointer to a struct) v against the rules
+// in its `validate` struct tags, and returns ValidationErrors if any fail.
+// Rules are separated by commas, and the supported rules are:
+//
+//	required   the value must not be empty (or zero)
+//	min=n      strings and slices must have at least n characters or
+//	           items, numbers must be at least n
+//	max=n      as min, but at most n
+//	len=n      strings and slices must have exactly n characters or items
+//	email      the value must be a plain email address
+//	regex=re   the value must match the regular expression re, which
+//	           must be the last rule as it may itself contain commas
+//
+// Apart from required, rules are not checked for empty values. Errors
+// are reported using the form name of the field (see Bind), and fields
+// of nested structs are checked too, reported as "parent.field". The
+// tags of a struct type are parsed the first time it is validated, and
+// an error that is not ValidationErrors is returned if they are invalid,
+// eg. for an unknown rule.
+func Validate(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return errors.New("validate: value must be a struct")
+	}
+	errs, err := validateStruct(rv, "")
+	if err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return errs.dedupe()
+	}
+	return nil
+}
+
+// validateStruct checks every tagged field of the struct v, and fields
+// of nested structs, whose errors are reported as "parent.field". It
+// returns an error if the tags of the struct are invalid.
+func validateStruct(v reflect.Value, prefix string) (ValidationErrors, error) {
+	fields, err := structRules(v.Type())
+	if err != nil {
+		return nil, err
+	}
+	var errs ValidationErrors
+	for _, f := range fields {
+		fv := v.Field(f.index)
+		for _, r := range f.rules {
+			if msg := r.check(fv); msg != "" {
+				errs = append(errs, FieldError{Field: prefix + f.name, Rule: r.name, Message: msg})
+				break
+			}
+		}
+		if f.embedded || f.nested {
+			for fv.Kind() == reflect.Ptr && !fv.IsNil() {
+				fv = fv.Elem()
+			}
+			if fv.Kind() != reflect.Struct {
+				continue
+			}
+			p := prefix
+			if f.nested {
+				p += f.name + "."
+			}
+			nested, err := validateStruct(fv, p)
+			if err != nil {
+				return nil, err
+			}
+			errs = append(errs, nested...)
+		}
+	}
+	return errs, nil
+}
+
+// fieldRules are the parsed validation rules of a struct field
+type fieldRules struct {
+	index    int
+	name     string
+	rules    []rule
+	embedded bool // embedded structs are validated as part of their parent
+	nested   bool // nested structs are validated under the name of the field
+}
+
+// rule is a single parsed validation rule
+type rule struct {
+	name string
+	n    float64        // the argument of min, max and len
+	re   *regexp.Regexp // the expression of regex
+}
+
+// structRulesEntry is a cached result of structRules
+type structRulesEntry struct {
+	fields []fieldRules
+	err    error
+}
+
+var rulesCache sync.Map
+
+// structRules returns the parsed rules of the fields of the struct type,
+// parsing the tags the first time the type is seen, and an error if any
+// of them are invalid
+func structRules(t reflect.Type) ([]fieldRules, error) {
+	if e, ok := rulesCache.Load(t); ok {
+		e := e.(*structRulesEntry)
+		return e.fields, e.err
+	}
+	fields, err := parseStructRules(t)
+	rulesCache.Store(t, &structRulesEntry{fields: fields, err: err})
+	return fields, err
+}
+
+func parseStructRules(t reflect.Type) ([]fieldRules, error) {
+	var fields []fieldRules
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			fields = append(fields, fieldRules{index: i, embedded: true})
+			continue
+		}
+		tag := f.Tag.Get("validate")
+		if tag == "-" || f.PkgPath != "" {
+			continue
+		}
+		fr := fieldRules{index: i, name: fieldKey(f)}
+		fr.nested = ft.Kind() == reflect.Struct && ft != timeType
+		for _, s := range splitRules(tag) {
+			r, err := parseRule(s, ft)
+			if err != nil {
+				return nil, fmt.Errorf("validate: %s.%s: %w", t, f.Name, err)
+			}
+			fr.rules = append(fr.rules, r)
+		}
+		if len(fr.rules) > 0 || fr.nested {
+			fields = append(fields, fr)
+		}
+	}
+	return fields, nil
+}
+
+// parseRule parses a single rule for a field of type t
+func parseRule(s string, t reflect.Type) (rule, error) {
+	r := rule{name: s}
+	var arg string
+	if i := strings.IndexByte(s, '='); i > -1 {
+		r.name, arg = s[:i], s[i+1:]
+	}
+	switch r.name {
+	case "required", "email":
+	case "min", "max", "len":
+		n, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return r, fmt.Errorf("invalid %s value %q", r.name, arg)
+		}
+		r.n = n
+		switch t.Kind() {
+		case reflect.String, reflect.Slice, reflect.Array, reflect.Map,
+			reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+		default:
+			return r, fmt.Errorf("%s is not supported for %s", r.name, t)
+		}
+	case "regex":
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return r, fmt.Errorf("invalid regex %q: %w", arg, err)
+		}
+		r.re = re
+	default:
+		return r, fmt.Errorf("unknown rule %q", r.name)
+	}
+	return r, nil
+}
+
+// fieldKey returns the name errors for a field are reported under, which
+// is its form name, or its json name for fields not bound from forms
+func fieldKey(f reflect.StructField) string {
+	if name, ok := formName(f); ok {
+		return name
+	}
+	if tag := f.Tag.Get("json"); tag != "" && tag != "-" {
+		if i := strings.IndexByte(tag, ','); i > -1 {
+			tag = tag[:i]
+		}
+		if tag != "" {
+			return tag
+		}
+	}
+	return strings.ToLower(f.Name)
+}
+
+// splitRules splits a validate tag into its rules, keeping
+// everything after "regex=" as a single rule
+func splitRules(tag string) []string {
+	var rules []string
+	for tag != "" {
+		if strings.HasPrefix(tag, "regex=") {
+			rules = append(rules, tag)
+			break
+		}
+		i := strings.IndexByte(tag, ',')
+		if i < 0 {
+			rules = append(rules, strings.TrimSpace(tag))
+			break
+		}
+		if r := strings.TrimSpace(tag[:i]); r != "" {
+			rules = append(rules, r)
+		}
+		tag = tag[i+1:]
+	}
+	return rules
+}
+
+// check returns a message if the value fails the rule
+func (r rule) check(v reflect.Value) string {
+	if r.name == "required" {
+		if isEmpty(v) {
+			return "is required"
+		}
+		return ""
+	}
+	if isEmpty(v) {
+		return ""
+	}
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	switch r.name {
+	case "min", "max", "len":
+		return checkSize(v, r.name, r.n)
+	case "email":
+		s := fmt.Sprint(v.Interface())
+		a, err := mail.ParseAddress(s)
+		if err != nil || a.Address != s {
+			return "must be a valid email address"
+		}
+	case "regex":
+		if !r.re.MatchString(fmt.Sprint(v.Interface())) {
+			return "is not in the correct format"
+		}
+	}
+	return ""
+}
+
+// checkSize checks the length of strings and slices, or the
+// value of numbers, against the min, max or len rule
+func checkSize(v reflect.Value, rule string, n float64) string {
+	var size float64
+	var unit string
+	switch v.Kind() {
+	case reflect.String:
+		size, unit = float64(utf8.RuneCountInString(v.String())), "characters"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		size, unit = float64(v.Len()), "items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		size = float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		size = float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		size = v.Float()
+	default:
+		// other types are refused when the rules are parsed
+		return ""
+	}
+	ns := strconv.FormatFloat(n, 'f', -1, 64)
+	if unit != "" {
+		ns += " " + unit
+	}
+	switch {
+	case rule == "min" && size < n:
+		if unit == "" {
+			return "must be at least " + ns
+		}
+		return "must have at least " + ns
+	case rule == "max" && size > n:
+		if unit == "" {
+			return "must be at most " + ns
+		}
+		return "must have at most " + ns
+	case rule == "len" && size != n:
+		return "must have exactly " + ns
+	}
+	return ""
+}
+
+// isEmpty reports whether the value is the zero value for its type,
+// treating strings made up only of whitespace as empty
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String:
+		return strings.TrimSpace(v.String()) == ""
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return v.IsZero()
+}
@@ -5,6 +5,7 @@ import "net/http"
 type WebAppConfig struct {
 	Templates          *TemplateConfig
 	Sessions           *SessionConfig
+	Auth               *AuthConfig // Auth is used if there are Sessions
 	Muxer              *MuxerConfig
 	Server             *ServerConfig
 	AppName            string
@@ -18,8 +19,10 @@ type WebApp struct {
 	*SessionStore
 	*Muxer
 	*Server
-	onSuccess http.Handler
-	onFailure http.Handler
+	*Renderer
+	Authz    *Authorizer // Authz authorizes requests, if there are Sessions
+	auth     *AuthConfig
+	remember *rememberer
 }
 
 func NewWebApp(conf *WebAppConfig) *WebApp {
@@ -36,16 +39,24 @@ func NewWebApp(conf *WebAppConfig) *WebApp {
 	if conf.Templates != nil {
 		app.TemplateCache = NewTemplateCache(conf.Templates)
 	}
-	if conf.Sessions != nil {
-		app.SessionStore = NewSessionStore(conf.Sessions)
-		app.AuthUser = NewSystemSessionUser()
-	}
 	if conf.Muxer != nil {
 		app.Muxer = NewMuxer(conf.Muxer)
 	}
+	if conf.Sessions != nil {
+		app.SessionStore = NewSessionStore(conf.Sessions)
+		app.initAuth(conf.Auth)
+	}
 	if conf.Server != nil {
 		app.Server = NewServer(conf.Server)
 	}
+	if app.TemplateCache != nil && app.Muxer != nil {
+		app.TemplateCache.Funcs(app.Muxer.URLFuncMap())
+	}
+	if app.Muxer != nil {
+		app.Renderer = NewRenderer(app.TemplateCache, app.Muxer.Logger(), app.Muxer.Error)
+	} else {
+		app.Renderer = NewRenderer(app.TemplateCache, nil, nil)
+	}
 	if conf.AppName == "" {
 		conf.AppName = "Go WebApp"
 	}
@@ -55,98 +66,15 @@ func NewWebApp(conf *WebAppConfig) *WebApp {
 	return app
 }
 
-func (app *WebApp) Redirect(url string) http.Handler {
-	return http.RedirectHandler(url, http.StatusTemporaryRedirect)
-}
-
-func (app *WebApp) handleLogin() http.Handler {
-	fn := func(w http.ResponseWriter, r *http.Request) {
-		// handle GET /login
-		if r.Method == http.MethodGet {
-			app.TemplateCache.ExecuteTemplate(w, "login.html", map[string]interface{}{})
-			return
-		}
-		// handle POST /login
-		if r.Method == http.MethodPost {
-			// get posted form values
-			un := r.FormValue("username")
-			pw := r.FormValue("password")
-			// attempt to authenticate
-			user, ok := app.AuthUser.Authenticate(un, pw)
-			if !ok {
-				// if authentication failed call onFailure
-				app.onFailure.ServeHTTP(w, r)
-				return
-			}
-			// otherwise, start a new session
-			sess := app.SessionStore.New()
-			sess.Set("role", user.Role)
-			sess.Set("username", user.Username)
-			app.SessionStore.Save(w, r, sess)
-			// call our onSuccess
-			app.onSuccess.ServeHTTP(w, r)
-			return
-		}
+// CSRF returns the CSRF middleware (see CSRF) for the
+// session store and muxer of the web app
+func (app *WebApp) CSRF() Middleware {
+	if app.SessionStore == nil {
+		panic("webapp: CSRF requires a session store")
 	}
-	return http.HandlerFunc(fn)
+	return CSRF(app.SessionStore, app.Muxer)
 }
 
-func (app *WebApp) HandleLogin() http.Handler {
-	fn := func(w http.ResponseWriter, r *http.Request) {
-		// reject non-post login calls
-		if r.Method != http.MethodPost {
-			code := http.StatusMethodNotAllowed
-			http.Error(w, http.StatusText(code), code)
-			return
-		}
-		// get posted form values
-		un := r.FormValue("username")
-		pw := r.FormValue("password")
-		// attempt to authenticate
-		user, ok := app.AuthUser.Authenticate(un, pw)
-		if !ok {
-			code := http.StatusUnauthorized
-			http.Error(w, http.StatusText(code), code)
-			return
-		}
-		// otherwise, start a new session
-		sess := app.SessionStore.New()
-		sess.Set("role", user.Role)
-		sess.Set("username", user.Username)
-		app.SessionStore.Save(w, r, sess)
-		// call onSuccess
-		app.onSuccess.ServeHTTP(w, r)
-		return
-	}
-	return http.HandlerFunc(fn)
-}
-
-func (app *WebApp) HandleRegister() http.Handler {
-	fn := func(w http.ResponseWriter, r *http.Request) {
-		// reject non-post login calls
-		if r.Method != http.MethodPost {
-			code := http.StatusMethodNotAllowed
-			http.Error(w, http.StatusText(code), code)
-			return
-		}
-		// get posted form values
-		un := r.FormValue("username")
-		pw := r.FormValue("password")
-		// attempt to authenticate
-		user, ok := app.AuthUser.Authenticate(un, pw)
-		if !ok {
-			code := http.StatusUnauthorized
-			http.Error(w, http.StatusText(code), code)
-			return
-		}
-		// otherwise, start a new session
-		sess := app.SessionStore.New()
-		sess.Set("role", user.Role)
-		sess.Set("username", user.Username)
-		app.SessionStore.Save(w, r, sess)
-		// call onSuccess
-		app.onSuccess.ServeHTTP(w, r)
-		return
-	}
-	return http.HandlerFunc(fn)
+func (app *WebApp) Redirect(url string) http.Handler {
+	return http.RedirectHandler(url, http.StatusTemporaryRedirect)
 }